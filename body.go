@@ -0,0 +1,148 @@
+package routemaster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultMaxBodySize = 10 << 20
+
+	// maxBodyExcerpt is the number of bytes of a malformed body kept for
+	// error reports.
+	maxBodyExcerpt = 1 << 10
+)
+
+// errNoEvents is reported for deliveries of an empty batch.
+var errNoEvents = errors.New("no events")
+
+// errReader records the errors of the reader it wraps, so that failures to
+// read a request body can be told apart from malformed bodies.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		e.err = err
+	}
+	return n, err
+}
+
+// bodyReader reads a decompressed request body, keeping its first bytes for
+// error reports.
+type bodyReader struct {
+	r       io.Reader
+	n       int64
+	excerpt []byte
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if keep := maxBodyExcerpt - len(b.excerpt); keep > 0 {
+		if keep > n {
+			keep = n
+		}
+		b.excerpt = append(b.excerpt, p[:keep]...)
+	}
+	b.n += int64(n)
+	return n, err
+}
+
+// malformed returns the error reported for a body that could not be decoded,
+// quoting its first bytes. The excerpt is completed first, since decoding may
+// have stopped before all of it had been read.
+func (b *bodyReader) malformed(err error) error {
+	if keep := maxBodyExcerpt - len(b.excerpt); keep > 0 {
+		_, _ = io.CopyN(ioutil.Discard, b, int64(keep))
+	}
+	excerpt := string(b.excerpt)
+	if b.n > int64(len(b.excerpt)) {
+		excerpt += fmt.Sprintf("... (%d bytes read)", b.n)
+	}
+	return fmt.Errorf("body malformed: %v: %s", err, excerpt)
+}
+
+// readEvents decodes the events of a delivery as its body is read,
+// decompressing it according to its Content-Encoding. It returns the status
+// code to reply with if it fails. MaxBodySize applies to the body both before
+// and after decompression.
+func (l *Listener) readEvents(w http.ResponseWriter, r *http.Request) ([]*ReceivedEvent, int, error) {
+	raw := &errReader{r: r.Body}
+	if l.maxBodySize > 0 {
+		raw.r = http.MaxBytesReader(w, r.Body, l.maxBodySize)
+	}
+	fail := func(err error) (int, error) {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			return http.StatusRequestEntityTooLarge, fmt.Errorf("body too large: limit is %d bytes", tooLarge.Limit)
+		case raw.err != nil:
+			return http.StatusInternalServerError, errors.New("request body read failed")
+		}
+		return http.StatusBadRequest, fmt.Errorf("body malformed: %v", err)
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	decompressed, err := decompress(encoding, raw)
+	if err == errUnsupportedEncoding {
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("%v: %q", err, encoding)
+	}
+	if err != nil {
+		code, err := fail(err)
+		return nil, code, err
+	}
+	if decompressed != raw && l.maxBodySize > 0 {
+		decompressed = http.MaxBytesReader(w, ioutil.NopCloser(decompressed), l.maxBodySize)
+	}
+
+	body := &bodyReader{r: decompressed}
+	events, err := decodeEvents(body)
+	if err != nil {
+		code, failure := fail(err)
+		if code == http.StatusBadRequest {
+			failure = body.malformed(err)
+		}
+		return nil, code, failure
+	}
+	return events, 0, nil
+}
+
+// decodeEvents decodes a JSON array of events one at a time, so that the body
+// does not need to be buffered.
+func decodeEvents(r io.Reader) ([]*ReceivedEvent, error) {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('[') {
+		return nil, fmt.Errorf("expected an array, got %v", t)
+	}
+	var events []*ReceivedEvent
+	for dec.More() {
+		var e *ReceivedEvent
+		if err := dec.Decode(&e); err != nil {
+			return nil, err
+		}
+		if e == nil {
+			return nil, fmt.Errorf("event %d is null", len(events))
+		}
+		events = append(events, e)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after events")
+	}
+	if len(events) == 0 {
+		return nil, errNoEvents
+	}
+	return events, nil
+}
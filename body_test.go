@@ -0,0 +1,71 @@
+package routemaster
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeEvents(t *testing.T) {
+	tests := []struct {
+		body   string
+		events int
+		err    bool
+	}{
+		{`[{"topic":"orders","url":"https://orders/1"}]`, 1, false},
+		{` [ {"topic":"orders"} , {"topic":"riders"} ] ` + "\n", 2, false},
+		{`[]`, 0, true},
+		{``, 0, true},
+		{`{"topic":"orders"}`, 0, true},
+		{`[{"topic":"orders"}`, 0, true},
+		{`[{"topic":"orders"}] []`, 0, true},
+		{`[null]`, 0, true},
+		{`[{"topic":1}]`, 0, true},
+	}
+	for _, tt := range tests {
+		events, err := decodeEvents(strings.NewReader(tt.body))
+		if (err != nil) != tt.err {
+			t.Errorf("%q: got error %v, want error: %v", tt.body, err, tt.err)
+		}
+		if len(events) != tt.events {
+			t.Errorf("%q: got %d events, want %d", tt.body, len(events), tt.events)
+		}
+	}
+}
+
+func TestListenerBodyLimits(t *testing.T) {
+	var failure error
+	listener := NewListener(&ListenerConfig{
+		Handler:     func(events []*ReceivedEvent) error { return nil },
+		OnError:     func(err error) { failure = err },
+		UUID:        "secret",
+		MaxBodySize: 100,
+	})
+	post := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		req.SetBasicAuth("secret", "")
+		w := httptest.NewRecorder()
+		listener.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	event := `{"topic":"orders","type":"update","url":"https://orders/1","t":1}`
+	if code := post("[" + event + "]"); code != http.StatusOK {
+		t.Errorf("small batch: got %d, want %d", code, http.StatusOK)
+	}
+	if code := post("[" + event + "," + event + "]"); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large batch: got %d, want %d", code, http.StatusRequestEntityTooLarge)
+	}
+
+	// Only the start of malformed bodies is reported.
+	listener.maxBodySize = -1
+	body := "[" + strings.Repeat(" ", 10*maxBodyExcerpt) + "x]"
+	if code := post(body); code != http.StatusBadRequest {
+		t.Errorf("malformed batch: got %d, want %d", code, http.StatusBadRequest)
+	}
+	if failure == nil || len(failure.Error()) > 2*maxBodyExcerpt {
+		t.Errorf("malformed batch: got error %v", failure)
+	}
+}
@@ -2,14 +2,15 @@ package routemaster
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // M is shorthand for map[string]interface{}.
@@ -17,33 +18,74 @@ type M map[string]interface{}
 
 // Config specifies the parameters needed to instantiate a Client.
 type Config struct {
-	// IgnoreSSL disables SSL verification for URL.
+	// IgnoreSSL disables SSL verification for URL. Prefer setting TLS with
+	// the CA of the bus.
 	IgnoreSSL bool
 
+	// TLS configures custom root CAs, client certificates for mutual TLS,
+	// and other TLS settings. Optional; replaces the TLS configuration of
+	// the transport of HTTPClient.
+	TLS *ClientTLSConfig
+
 	// URL is the URL of the Routemaster bus.
 	URL string
 
 	// UUID is the unique client identifier.
 	UUID string
+
+	// TokenSource returns the UUID for every request, so that it can be
+	// rotated without rebuilding the client. Optional; takes precedence over
+	// UUID, which must be set otherwise.
+	TokenSource TokenSource
+
+	// Retry specifies how failed requests are retried. Optional; if nil,
+	// requests are attempted only once.
+	Retry *RetryPolicy
+
+	// HTTPClient is the client used to make requests. Optional; defaults to
+	// http.DefaultClient. It is not modified: if IgnoreSSL or Middleware are
+	// set, a copy with a wrapped transport is used instead.
+	HTTPClient *http.Client
+
+	// Middleware wraps the transport of HTTPClient, the first middleware
+	// being the outermost. Every request made by the client, including
+	// retries, goes through the chain.
+	Middleware []Middleware
+
+	// Gzip compresses request bodies, e.g. those of Push, with gzip. The bus
+	// must accept gzip-encoded requests. Responses are decompressed
+	// whether or not it is set.
+	Gzip bool
+
+	// Metrics receives a measurement for every request attempt. Optional.
+	Metrics Metrics
+
+	// Log receives a structured record for every request attempt:
+	// successes at debug level, retries at warn level and failures at error
+	// level. Optional; nothing is logged if nil.
+	Log *slog.Logger
 }
 
 func (c *Config) validate() error {
 	if c.URL == "" {
-		return errors.New("URL must not be empty")
+		return &ValidationError{Field: "URL", Message: "must not be empty"}
 	}
 	if !isValidAbsoluteURL(c.URL) {
-		return errors.New("URL must be a valid absolute url")
+		return &ValidationError{Field: "URL", Message: "must be a valid absolute URL"}
 	}
-	if c.UUID == "" {
-		return errors.New("UUID most not be empty")
+	if c.UUID == "" && c.TokenSource == nil {
+		return &ValidationError{Field: "UUID", Message: "must not be empty"}
 	}
 	return nil
 }
 
 // Client is a Routemaster API client.
 type Client struct {
-	config *Config
-	client *http.Client
+	config  *Config
+	client  *http.Client
+	tokens  TokenSource
+	metrics Metrics
+	logger  *slog.Logger
 }
 
 // NewClient instantiates a new Routemaster API client.
@@ -52,56 +94,137 @@ func NewClient(config *Config) (*Client, error) {
 		return nil, err
 	}
 
-	client := http.DefaultClient
-	if config.IgnoreSSL {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		client = &http.Client{Transport: tr}
+	client, err := newHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+	tokens := config.TokenSource
+	if tokens == nil {
+		tokens = StaticToken(config.UUID)
 	}
 	return &Client{
-		config: config,
-		client: client,
+		config:  config,
+		client:  client,
+		tokens:  tokens,
+		metrics: defaultMetrics(config.Metrics),
+		logger:  clientLogger(config.Log),
 	}, nil
 }
 
-func (c *Client) do(method, path string, body interface{}, result interface{}) error {
+// do performs a request against the bus, retrying it according to the
+// configured RetryPolicy. If ctx is cancelled or its deadline expires before
+// the request completes, the context's error is returned unwrapped so that
+// callers can compare it against context.Canceled and
+// context.DeadlineExceeded. Other transport errors are returned as a
+// *NetworkError, and unsuccessful responses as an *APIError.
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
 	bodyBytes, err := json.Marshal(body)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest(method,
+	payload, encoding := bodyBytes, ""
+	if c.config.Gzip && body != nil {
+		if payload, err = gzipBytes(bodyBytes); err != nil {
+			return err
+		}
+		encoding = "gzip"
+	}
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		req, resp, err := c.send(ctx, method, path, payload, encoding)
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				c.logAttempt(ctx, method, path, attempt, nil, ctxErr, time.Since(start), false, 0)
+				return ctxErr
+			}
+			if !c.config.Retry.shouldRetry(method, attempt, 0, err) {
+				c.logAttempt(ctx, method, path, attempt, nil, err, time.Since(start), false, 0)
+				return err
+			}
+			wait = c.config.Retry.backoff(attempt, nil)
+		case !isHTTPSuccess(resp.StatusCode) &&
+			c.config.Retry.shouldRetry(method, attempt, resp.StatusCode, nil):
+			// Drain the body so that the connection can be reused.
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			wait = c.config.Retry.backoff(attempt, resp.Header)
+		default:
+			c.logAttempt(ctx, method, path, attempt, resp, nil, time.Since(start), false, 0)
+			return handleResponse(req, resp, bodyBytes, result)
+		}
+		c.logAttempt(ctx, method, path, attempt, resp, err, time.Since(start), true, wait)
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// send makes a single attempt at a request, whose body is encoded with
+// encoding if not empty.
+func (c *Client) send(ctx context.Context, method, path string, body []byte, encoding string) (*http.Request, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method,
 		c.config.URL+path,
-		bytes.NewBuffer(bodyBytes))
+		bytes.NewBuffer(body))
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	token, err := c.tokens.Token(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("routemaster: getting token failed: %w", err)
 	}
-	req.SetBasicAuth(c.config.UUID, "")
+	req.SetBasicAuth(token, "")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if method == http.MethodGet {
+		// Setting Accept-Encoding disables the transparent decompression of
+		// the transport, which handleResponse does instead, so that it does
+		// not depend on the transport of HTTPClient.
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+	start := time.Now()
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return err
+		c.metrics.ClientRequest(method, routeOf(path), 0, time.Since(start))
+		return req, nil, &NetworkError{Err: err}
 	}
+	c.metrics.ClientRequest(method, routeOf(path), resp.StatusCode, time.Since(start))
+	return req, resp, nil
+}
+
+// handleResponse turns an unsuccessful response into an *APIError, and
+// decodes a successful one into result if it is not nil.
+func handleResponse(req *http.Request, resp *http.Response, reqBody []byte, result interface{}) error {
+	defer resp.Body.Close()
+	respBody, decompressErr := decompressResponse(resp)
 	if !isHTTPSuccess(resp.StatusCode) {
-		defer resp.Body.Close()
+		if decompressErr != nil {
+			// Report the status rather than the failure to decompress.
+			respBody = resp.Body
+		}
 		body := &strings.Builder{}
-		_, _ = io.Copy(body, resp.Body)
+		_, _ = io.Copy(body, respBody)
 
 		// Dump request headers sans Authorization.
 		reqHeaders := &strings.Builder{}
 		_ = req.Header.WriteSubset(reqHeaders, excludedHeaders)
 
-		return &clientError{
+		return &APIError{
 			status:      resp.Status,
 			statusCode:  resp.StatusCode,
 			respHeaders: resp.Header,
 			respBody:    body.String(),
 			reqHeaders:  reqHeaders.String(),
-			reqBody:     bodyBytes,
+			reqBody:     reqBody,
 		}
 	}
 	if result != nil {
-		defer resp.Body.Close()
-		buf, err := ioutil.ReadAll(resp.Body)
+		if decompressErr != nil {
+			return decompressErr
+		}
+		buf, err := ioutil.ReadAll(respBody)
 		if err != nil {
 			return err
 		}
@@ -112,48 +235,6 @@ func (c *Client) do(method, path string, body interface{}, result interface{}) e
 	return nil
 }
 
-// excludedHeaders satisfies the API of http.Header.WriteSubset.
-var excludedHeaders = map[string]bool{"Authorization": true}
-
-type clientError struct {
-	status      string
-	statusCode  int
-	reqBody     []byte
-	reqHeaders  string
-	respBody    string
-	respHeaders http.Header
-}
-
-func (e *clientError) ResponseBody() string {
-	return e.respBody
-}
-
-func (e *clientError) HTTPStatusCode() int {
-	return e.statusCode
-}
-
-func (e *clientError) ResponseHeaders() http.Header {
-	return e.respHeaders
-}
-
-func (e *clientError) RequestBody() []byte {
-	return e.reqBody
-}
-
-func (e *clientError) RequestHeaders() string {
-	return e.reqHeaders
-}
-
-func (e *clientError) Error() string {
-	return fmt.Sprintf("routemaster/client: status=%s response=%s request=%s respHeaders=%v requestHeaders=%v",
-		e.status,
-		e.respBody,
-		string(e.reqBody),
-		e.respHeaders,
-		string(e.reqHeaders),
-	)
-}
-
 func isHTTPSuccess(statusCode int) bool {
 	switch statusCode {
 	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
@@ -164,61 +245,106 @@ func isHTTPSuccess(statusCode int) bool {
 
 // GetTokens retrieves all registered API tokens.
 func (c *Client) GetTokens() ([]*Token, error) {
+	return c.GetTokensContext(context.Background())
+}
+
+// GetTokensContext is like GetTokens but uses the given context.
+func (c *Client) GetTokensContext(ctx context.Context) ([]*Token, error) {
 	var response []*Token
-	err := c.do(http.MethodGet, "/api_tokens", nil, &response)
+	err := c.do(ctx, http.MethodGet, "/api_tokens", nil, &response)
 	return response, err
 }
 
 // CreateToken creates an API token.
 func (c *Client) CreateToken(name string) (string, error) {
+	return c.CreateTokenContext(context.Background(), name)
+}
+
+// CreateTokenContext is like CreateToken but uses the given context.
+func (c *Client) CreateTokenContext(ctx context.Context, name string) (string, error) {
 	var response Token
-	err := c.do(http.MethodPost, "/api_tokens", M{"name": name}, &response)
+	err := c.do(ctx, http.MethodPost, "/api_tokens", M{"name": name}, &response)
 	return response.Token, err
 }
 
 // DeleteToken deletes an API token.
 func (c *Client) DeleteToken(token string) error {
+	return c.DeleteTokenContext(context.Background(), token)
+}
+
+// DeleteTokenContext is like DeleteToken but uses the given context.
+func (c *Client) DeleteTokenContext(ctx context.Context, token string) error {
 	path := fmt.Sprintf("/api_tokens/%s", token)
-	return c.do(http.MethodDelete, path, nil, nil)
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
 }
 
 // Subscribe subscribes a listener to a Routemaster topic.
 func (c *Client) Subscribe(s *Subscription) error {
+	return c.SubscribeContext(context.Background(), s)
+}
+
+// SubscribeContext is like Subscribe but uses the given context.
+func (c *Client) SubscribeContext(ctx context.Context, s *Subscription) error {
 	if err := s.validate(); err != nil {
 		return err
 	}
-	return c.do(http.MethodPost, "/subscription", s, nil)
+	return c.do(ctx, http.MethodPost, "/subscription", s, nil)
 }
 
 // DeleteTopic deletes the specified topic.
 func (c *Client) DeleteTopic(topic string) error {
+	return c.DeleteTopicContext(context.Background(), topic)
+}
+
+// DeleteTopicContext is like DeleteTopic but uses the given context.
+func (c *Client) DeleteTopicContext(ctx context.Context, topic string) error {
 	path := fmt.Sprintf("/topic/%s", topic)
-	return c.do(http.MethodDelete, path, nil, nil)
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
 }
 
 // Push pushes an event to the Routemaster bus.
 func (c *Client) Push(topic string, e *Event) error {
+	return c.PushContext(context.Background(), topic, e)
+}
+
+// PushContext is like Push but uses the given context.
+func (c *Client) PushContext(ctx context.Context, topic string, e *Event) error {
 	if err := e.validate(); err != nil {
 		return err
 	}
 	path := fmt.Sprintf("/topics/%s", topic)
-	return c.do(http.MethodPost, path, e, nil)
+	return c.do(ctx, http.MethodPost, path, e, nil)
 }
 
 // Unsubscribe unsubscribes a listener from a Routemaster topic.
 func (c *Client) Unsubscribe(topic string) error {
+	return c.UnsubscribeContext(context.Background(), topic)
+}
+
+// UnsubscribeContext is like Unsubscribe but uses the given context.
+func (c *Client) UnsubscribeContext(ctx context.Context, topic string) error {
 	path := fmt.Sprintf("/subscriber/topics/%s", topic)
-	return c.do(http.MethodDelete, path, nil, nil)
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
 }
 
 // UnsubscribeAll unsubscribes a listener from all topics.
 func (c *Client) UnsubscribeAll() error {
-	return c.do(http.MethodDelete, "/subscriber", nil, nil)
+	return c.UnsubscribeAllContext(context.Background())
+}
+
+// UnsubscribeAllContext is like UnsubscribeAll but uses the given context.
+func (c *Client) UnsubscribeAllContext(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/subscriber", nil, nil)
 }
 
 // GetTopics retrieves all topics.
 func (c *Client) GetTopics() ([]*Topic, error) {
+	return c.GetTopicsContext(context.Background())
+}
+
+// GetTopicsContext is like GetTopics but uses the given context.
+func (c *Client) GetTopicsContext(ctx context.Context) ([]*Topic, error) {
 	var result []*Topic
-	err := c.do(http.MethodGet, "/topics", nil, &result)
+	err := c.do(ctx, http.MethodGet, "/topics", nil, &result)
 	return result, err
 }
@@ -1,11 +1,14 @@
 package routemaster
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestValidatesParams(t *testing.T) {
@@ -213,3 +216,36 @@ func TestRequests(t *testing.T) {
 	}
 
 }
+
+func TestContext(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	client, err := NewClient(&Config{URL: ts.URL, UUID: "demo"})
+	must(err)
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := client.GetTopicsContext(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("error: got %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := client.PushContext(ctx, "orders", &Event{
+			Type: "create",
+			URL:  "https://orders/1",
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("error: got %v, want %v", err, context.Canceled)
+		}
+	})
+}
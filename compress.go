@@ -0,0 +1,69 @@
+package routemaster
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// errUnsupportedEncoding is reported for bodies whose Content-Encoding is not
+// supported.
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// decompress returns a reader decompressing body according to encoding,
+// which must be lowercase.
+func decompress(encoding string, body io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		// Deflate bodies should be zlib streams, but some senders use raw
+		// deflate streams instead.
+		br := bufio.NewReader(body)
+		header, err := br.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, errUnsupportedEncoding
+}
+
+// decompressResponse returns the decompressed body of resp.
+func decompressResponse(resp *http.Response) (io.Reader, error) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	body, err := decompress(encoding, resp.Body)
+	if err == io.EOF {
+		// Empty bodies are left as is.
+		return resp.Body, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("routemaster: decompressing response failed: %w", err)
+	}
+	return body, nil
+}
+
+// gzipBytes compresses b with gzip.
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
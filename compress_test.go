@@ -0,0 +1,122 @@
+package routemaster
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientGzip(t *testing.T) {
+	var (
+		encoding string
+		body     []byte
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		body, _ = ioutil.ReadAll(r.Body)
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			t.Errorf("Accept-Encoding: got %q", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(`[{"name":"orders","publisher":"demo","events":3}]`))
+		zw.Close()
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(&Config{URL: ts.URL, UUID: "demo", Gzip: true})
+	must(err)
+	must(client.Push("orders", &Event{Type: "create", URL: "https://orders/1"}))
+	if encoding != "gzip" {
+		t.Errorf("Content-Encoding: got %q, want %q", encoding, "gzip")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	must(err)
+	if b, _ := ioutil.ReadAll(zr); !strings.Contains(string(b), "https://orders/1") {
+		t.Errorf("body: got %q", b)
+	}
+
+	topics, err := client.GetTopics()
+	must(err)
+	if len(topics) != 1 || topics[0].Name != "orders" {
+		t.Errorf("topics: got %v", topics)
+	}
+	if encoding != "" {
+		t.Errorf("GET Content-Encoding: got %q, want none", encoding)
+	}
+}
+
+func TestListenerCompression(t *testing.T) {
+	const batch = `[{"topic":"orders","type":"update","url":"https://orders/1","t":1}]`
+	compress := func(newWriter func(io.Writer) io.WriteCloser, s string) string {
+		var buf bytes.Buffer
+		zw := newWriter(&buf)
+		zw.Write([]byte(s))
+		zw.Close()
+		return buf.String()
+	}
+	gzipped := func(s string) string {
+		return compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }, s)
+	}
+	zlibbed := func(s string) string {
+		return compress(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }, s)
+	}
+	deflated := func(s string) string {
+		return compress(func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		}, s)
+	}
+
+	var handled int
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error {
+			handled += len(events)
+			return nil
+		},
+		OnError:     func(error) {},
+		UUID:        "secret",
+		MaxBodySize: 1000,
+	})
+	tests := []struct {
+		name     string
+		encoding string
+		body     string
+		want     int
+	}{
+		{"gzip", "gzip", gzipped(batch), http.StatusOK},
+		{"zlib deflate", "deflate", zlibbed(batch), http.StatusOK},
+		{"raw deflate", "Deflate", deflated(batch), http.StatusOK},
+		{"identity", "identity", batch, http.StatusOK},
+		{"corrupt gzip", "gzip", batch, http.StatusBadRequest},
+		{"truncated gzip", "gzip", gzipped(batch)[:20], http.StatusBadRequest},
+		{"unsupported", "br", batch, http.StatusUnsupportedMediaType},
+		// Compresses to much less than MaxBodySize.
+		{"bomb", "gzip", gzipped("[" + strings.Repeat(" ", 10000) + "]"), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		handled = 0
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+		req.SetBasicAuth("secret", "")
+		req.Header.Set("Content-Encoding", tt.encoding)
+		w := httptest.NewRecorder()
+		listener.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && handled != 1 {
+			t.Errorf("%s: handled %d events, want 1", tt.name, handled)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package routemaster
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCredentialName is the name of the credential set by
+// ListenerConfig.UUID.
+const defaultCredentialName = "uuid"
+
+// A Credential is a secret accepted by a Listener as the basic auth username
+// of deliveries, i.e. the UUID of a subscription.
+type Credential struct {
+	// Name identifies the credential in logs and hooks without revealing
+	// it.
+	Name string
+
+	// Secret is the credential itself. An empty Secret matches no
+	// delivery.
+	Secret string
+}
+
+// A CredentialProvider returns the credentials accepted by a Listener. It is
+// called for every delivery, so that credentials can be rotated without
+// restarting the Listener. Implementations must be safe for concurrent use.
+type CredentialProvider interface {
+	Credentials(ctx context.Context) ([]Credential, error)
+}
+
+// CredentialFunc adapts a function into a CredentialProvider.
+type CredentialFunc func(ctx context.Context) ([]Credential, error)
+
+// Credentials implements CredentialProvider.
+func (f CredentialFunc) Credentials(ctx context.Context) ([]Credential, error) {
+	return f(ctx)
+}
+
+// StaticCredentials returns a CredentialProvider accepting a fixed set of
+// credentials.
+func StaticCredentials(credentials ...Credential) CredentialProvider {
+	return CredentialFunc(func(context.Context) ([]Credential, error) {
+		return credentials, nil
+	})
+}
+
+// EnvCredentials returns a CredentialProvider reading credentials from an
+// environment variable whenever it is called. The variable holds a comma
+// separated list of secrets, each optionally prefixed with a name and a
+// colon:
+//
+//	ROUTEMASTER_UUIDS=current:8d5e...,previous:2f1c...
+//
+// Unnamed secrets are named after the variable and their position.
+func EnvCredentials(key string) CredentialProvider {
+	return CredentialFunc(func(context.Context) ([]Credential, error) {
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			return nil, fmt.Errorf("routemaster: %s is not set", key)
+		}
+		var credentials []Credential
+		for _, entry := range strings.Split(value, ",") {
+			if c, ok := parseCredential(entry, fmt.Sprintf("%s[%d]", key, len(credentials))); ok {
+				credentials = append(credentials, c)
+			}
+		}
+		return credentials, nil
+	})
+}
+
+// FileCredentials is a CredentialProvider reading credentials from a file,
+// which is read again whenever its modification time changes. The file holds
+// one secret per line, each optionally prefixed with a name and a colon.
+// Blank lines and lines starting with # are ignored.
+type FileCredentials struct {
+	path string
+
+	mu          sync.Mutex
+	modTime     time.Time
+	credentials []Credential
+}
+
+// NewFileCredentials creates a FileCredentials reading the file at path.
+func NewFileCredentials(path string) *FileCredentials {
+	return &FileCredentials{path: path}
+}
+
+// Credentials implements CredentialProvider.
+func (p *FileCredentials) Credentials(ctx context.Context) ([]Credential, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.credentials != nil && info.ModTime().Equal(p.modTime) {
+		return p.credentials, nil
+	}
+
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	credentials := []Credential{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if c, ok := parseCredential(text, fmt.Sprintf("%s:%d", p.path, line)); ok {
+			credentials = append(credentials, c)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	p.credentials, p.modTime = credentials, info.ModTime()
+	return credentials, nil
+}
+
+// parseCredential parses a secret optionally prefixed with a name and a
+// colon, using defaultName otherwise. Entries with an empty secret are
+// skipped, since they would accept deliveries without basic auth.
+func parseCredential(s, defaultName string) (Credential, bool) {
+	c := Credential{Name: defaultName, Secret: strings.TrimSpace(s)}
+	if name, secret, ok := strings.Cut(c.Secret, ":"); ok {
+		c = Credential{Name: strings.TrimSpace(name), Secret: strings.TrimSpace(secret)}
+	}
+	return c, c.Secret != ""
+}
+
+// errBadToken is reported when a delivery has no valid credential.
+var errBadToken = errors.New("bad token")
+
+// authenticate returns the name of the credential of a delivery. Secrets are
+// compared in constant time, hashed so that their length does not leak
+// either; all of them are compared even once one has matched. Empty secrets
+// never match, so that deliveries without basic auth are rejected.
+func (l *Listener) authenticate(r *http.Request) (string, error) {
+	credentials := l.staticCredentials
+	if l.credentials != nil {
+		var err error
+		if credentials, err = l.credentials.Credentials(r.Context()); err != nil {
+			return "", fmt.Errorf("loading credentials failed: %w", err)
+		}
+	}
+	username, _, _ := r.BasicAuth()
+	got := sha256.Sum256([]byte(username))
+	var (
+		name  string
+		match int
+	)
+	for _, c := range credentials {
+		if c.Secret == "" {
+			continue
+		}
+		want := sha256.Sum256([]byte(c.Secret))
+		if subtle.ConstantTimeCompare(got[:], want[:]) == 1 && match == 0 {
+			name, match = c.Name, 1
+		}
+	}
+	if match == 0 {
+		return "", errBadToken
+	}
+	return name, nil
+}
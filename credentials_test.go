@@ -0,0 +1,138 @@
+package routemaster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListenerCredentials(t *testing.T) {
+	var (
+		provider CredentialProvider = StaticCredentials(
+			Credential{Name: "current", Secret: "new-secret"},
+			Credential{Name: "previous", Secret: "old-secret"},
+		)
+		used []string
+	)
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error { return nil },
+		OnError: func(error) {},
+		UUID:    "ignored",
+		Credentials: CredentialFunc(func(ctx context.Context) ([]Credential, error) {
+			return provider.Credentials(ctx)
+		}),
+		OnAuth: func(credential string) { used = append(used, credential) },
+	})
+	post := func(username string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(
+			`[{"topic":"orders","type":"update","url":"https://orders/1","t":1}]`))
+		req.SetBasicAuth(username, "")
+		w := httptest.NewRecorder()
+		listener.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		username string
+		want     int
+	}{
+		{"new-secret", http.StatusOK},
+		{"old-secret", http.StatusOK},
+		{"ignored", http.StatusUnauthorized},
+		{"new-secre", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if got := post(tt.username); got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.username, got, tt.want)
+		}
+	}
+	if want := []string{"current", "previous"}; !reflect.DeepEqual(used, want) {
+		t.Errorf("credentials used: got %v, want %v", used, want)
+	}
+
+	provider = CredentialFunc(func(context.Context) ([]Credential, error) {
+		return nil, errors.New("unavailable")
+	})
+	if got := post("new-secret"); got != http.StatusInternalServerError {
+		t.Errorf("provider error: got %d, want %d", got, http.StatusInternalServerError)
+	}
+}
+
+func TestListenerEmptyCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	must(os.WriteFile(path, []byte("current:abc\nold: \n"), 0o600))
+	t.Setenv("ROUTEMASTER_UUIDS", "current:abc,old:")
+
+	tests := []struct {
+		name     string
+		provider CredentialProvider
+	}{
+		{"env", EnvCredentials("ROUTEMASTER_UUIDS")},
+		{"file", NewFileCredentials(path)},
+		{"static", StaticCredentials(Credential{Name: "old"})},
+	}
+	for _, tt := range tests {
+		listener := NewListener(&ListenerConfig{
+			Handler:     func(events []*ReceivedEvent) error { return nil },
+			OnError:     func(error) {},
+			Credentials: tt.provider,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(
+			`[{"topic":"orders","type":"update","url":"https://orders/1","t":1}]`))
+		w := httptest.NewRecorder()
+		listener.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	t.Setenv("ROUTEMASTER_UUIDS", "current:abc, def,old:,")
+	got, err := EnvCredentials("ROUTEMASTER_UUIDS").Credentials(context.Background())
+	must(err)
+	want := []Credential{
+		{Name: "current", Secret: "abc"},
+		{Name: "ROUTEMASTER_UUIDS[1]", Secret: "def"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := EnvCredentials("ROUTEMASTER_UNSET").Credentials(context.Background()); err == nil {
+		t.Error("expected an error for an unset variable")
+	}
+}
+
+func TestFileCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	must(os.WriteFile(path, []byte("# Rotated on Mondays.\ncurrent:abc\n\ndef\nold: \n"), 0o600))
+	p := NewFileCredentials(path)
+
+	got, err := p.Credentials(context.Background())
+	must(err)
+	want := []Credential{
+		{Name: "current", Secret: "abc"},
+		{Name: path + ":4", Secret: "def"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The file is read again once modified.
+	must(os.WriteFile(path, []byte("next:ghi\n"), 0o600))
+	must(os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	got, err = p.Credentials(context.Background())
+	must(err)
+	if want := []Credential{{Name: "next", Secret: "ghi"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after update: got %v, want %v", got, want)
+	}
+}
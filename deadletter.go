@@ -0,0 +1,237 @@
+package routemaster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Defaults applied to the zero fields of a DeadLetterConfig.
+const (
+	defaultDeadLetterMaxFailures = 3
+	defaultDeadLetterCacheSize   = 10000
+)
+
+// A DeadLetterSink stores events that a Listener gave up handling.
+// Implementations must be safe for concurrent use.
+type DeadLetterSink interface {
+	// DeadLetter stores an event along with the error of its last failure.
+	// The event is acknowledged to the bus only if DeadLetter succeeds.
+	DeadLetter(ctx context.Context, e *ReceivedEvent, cause error) error
+}
+
+// DeadLetterFunc adapts a function into a DeadLetterSink.
+type DeadLetterFunc func(ctx context.Context, e *ReceivedEvent, cause error) error
+
+// DeadLetter implements DeadLetterSink.
+func (f DeadLetterFunc) DeadLetter(ctx context.Context, e *ReceivedEvent, cause error) error {
+	return f(ctx, e, cause)
+}
+
+// DeadLetterConfig specifies when a Listener dead-letters events.
+//
+// An event that has failed MaxFailures times is passed to Sink instead of
+// failing its batch, and the rest of the batch is acknowledged once handled.
+// Unless the Listener has a ResultHandler, the events of a failed batch are
+// handled again one at a time, so that failures are attributed to the events
+// causing them.
+type DeadLetterConfig struct {
+	// Sink stores dead-lettered events.
+	Sink DeadLetterSink
+
+	// MaxFailures is the number of failures after which an event is
+	// dead-lettered. Defaults to 3.
+	MaxFailures int
+
+	// Key identifies events across deliveries. Defaults to DefaultDedupKey.
+	Key func(*ReceivedEvent) string
+
+	// CacheSize is the number of events whose failures are counted. When
+	// full, the least recently failed event is forgotten. Defaults to 10000.
+	CacheSize int
+}
+
+// deadLetterer dead-letters the events of a Listener.
+type deadLetterer struct {
+	sink        DeadLetterSink
+	maxFailures int
+	key         func(*ReceivedEvent) string
+
+	// mu makes incrementing failure counts atomic.
+	mu       sync.Mutex
+	failures *lruCache[int]
+}
+
+func newDeadLetterer(cfg *DeadLetterConfig) *deadLetterer {
+	d := &deadLetterer{
+		sink:        cfg.Sink,
+		maxFailures: cfg.MaxFailures,
+		key:         cfg.Key,
+	}
+	if d.maxFailures <= 0 {
+		d.maxFailures = defaultDeadLetterMaxFailures
+	}
+	if d.key == nil {
+		d.key = DefaultDedupKey
+	}
+	size := cfg.CacheSize
+	if size <= 0 {
+		size = defaultDeadLetterCacheSize
+	}
+	d.failures = newLRUCache[int](size, 0)
+	return d
+}
+
+// fail counts a failure of the event with the given key, and returns the
+// number of failures so far.
+func (d *deadLetterer) fail(key string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n, _ := d.failures.get(key)
+	n++
+	d.failures.set(key, n)
+	return n
+}
+
+// isolate handles the events of a batch one at a time, so that failures are
+// attributed to the events causing them.
+func (l *Listener) isolate(ctx context.Context, events []*ReceivedEvent) []EventResult {
+	results := make([]EventResult, len(events))
+	for i, e := range events {
+		results[i] = l.tryInvoke(ctx, []*ReceivedEvent{e})[0]
+	}
+	return results
+}
+
+// recordFailure counts a failure of an event, and dead-letters the event once
+// it has failed MaxFailures times. It returns nil if the event was
+// dead-lettered, and err otherwise.
+func (l *Listener) recordFailure(ctx context.Context, e *ReceivedEvent, err error) error {
+	key := l.deadLetter.key(e)
+	failures := l.deadLetter.fail(key)
+	if failures < l.deadLetter.maxFailures {
+		return err
+	}
+	if sinkErr := l.deadLetter.sink.DeadLetter(ctx, e, err); sinkErr != nil {
+		return fmt.Errorf("dead-lettering failed: %w (after: %w)", sinkErr, err)
+	}
+	l.deadLetter.failures.delete(key)
+	l.logger.LogAttrs(ctx, slog.LevelWarn, "routemaster event dead-lettered",
+		slog.String("topic", e.Topic),
+		slog.String("type", e.Type),
+		slog.String("url", e.URL),
+		slog.Int("failures", failures),
+		slog.Any("error", err))
+	return nil
+}
+
+// tryInvoke is like invoke, but turns panics into retries.
+func (l *Listener) tryInvoke(ctx context.Context, events []*ReceivedEvent) (results []EventResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			results = fill(len(events), EventResult{Outcome: OutcomeRetry, Err: fmt.Errorf("panic: %+v", r)})
+		}
+	}()
+	return l.invoke(ctx, events)
+}
+
+// DeadLetter is an event stored in a MemoryDeadLetterSink.
+type DeadLetter struct {
+	Event *ReceivedEvent
+	Err   error
+	At    time.Time
+}
+
+// MemoryDeadLetterSink is a DeadLetterSink keeping events in memory. It is
+// intended for tests.
+type MemoryDeadLetterSink struct {
+	mu      sync.Mutex
+	letters []*DeadLetter
+}
+
+// NewMemoryDeadLetterSink creates an empty MemoryDeadLetterSink.
+func NewMemoryDeadLetterSink() *MemoryDeadLetterSink {
+	return &MemoryDeadLetterSink{}
+}
+
+// DeadLetter implements DeadLetterSink.
+func (s *MemoryDeadLetterSink) DeadLetter(ctx context.Context, e *ReceivedEvent, cause error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters = append(s.letters, &DeadLetter{Event: e, Err: cause, At: time.Now()})
+	return nil
+}
+
+// Letters returns the events dead-lettered so far.
+func (s *MemoryDeadLetterSink) Letters() []*DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*DeadLetter(nil), s.letters...)
+}
+
+// FileDeadLetterSink is a DeadLetterSink appending events to a file, one JSON
+// object per line:
+//
+//	{"event":{"topic":"orders",...},"error":"...","at":"2006-01-02T15:04:05Z"}
+type FileDeadLetterSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileDeadLetterSink opens the file at path for appending, creating it if
+// needed.
+func NewFileDeadLetterSink(path string) (*FileDeadLetterSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileDeadLetterSink{f: f}, nil
+}
+
+// DeadLetter implements DeadLetterSink. The file is synced before DeadLetter
+// returns.
+func (s *FileDeadLetterSink) DeadLetter(ctx context.Context, e *ReceivedEvent, cause error) error {
+	b, err := json.Marshal(struct {
+		Event *ReceivedEvent `json:"event"`
+		Error string         `json:"error"`
+		At    time.Time      `json:"at"`
+	}{e, cause.Error(), time.Now()})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Close closes the file.
+func (s *FileDeadLetterSink) Close() error {
+	return s.f.Close()
+}
+
+// PushDeadLetterSink returns a DeadLetterSink pushing events to a topic of the
+// bus. The pushed event has the type, URL and timestamp of the original
+// event; its data holds the original topic, data and error:
+//
+//	{"topic":"orders","data":{...},"error":"..."}
+func PushDeadLetterSink(client *Client, topic string) DeadLetterSink {
+	return DeadLetterFunc(func(ctx context.Context, e *ReceivedEvent, cause error) error {
+		return client.PushContext(ctx, topic, &Event{
+			Type:      e.Type,
+			URL:       e.URL,
+			Timestamp: e.Timestamp,
+			Data: M{
+				"topic": e.Topic,
+				"data":  e.Data,
+				"error": cause.Error(),
+			},
+		})
+	})
+}
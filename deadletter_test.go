@@ -0,0 +1,174 @@
+package routemaster
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListenerDeadLetter(t *testing.T) {
+	tests := []struct {
+		name   string
+		poison func()
+	}{
+		{"error", func() {}},
+		{"panic", func() { panic("boom") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				sink    = NewMemoryDeadLetterSink()
+				handled []string
+			)
+			listener := NewListener(&ListenerConfig{
+				Handler: func(events []*ReceivedEvent) error {
+					for _, e := range events {
+						if e.URL == "https://orders/poison" {
+							tt.poison()
+							return errors.New("poisoned")
+						}
+					}
+					for _, e := range events {
+						handled = append(handled, e.URL)
+					}
+					return nil
+				},
+				OnError:    func(error) {},
+				UUID:       "secret",
+				DeadLetter: &DeadLetterConfig{Sink: sink, MaxFailures: 2},
+			})
+			post := func() int {
+				req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`[
+					{"topic":"orders","type":"update","url":"https://orders/1","t":1},
+					{"topic":"orders","type":"update","url":"https://orders/poison","t":2},
+					{"topic":"orders","type":"update","url":"https://orders/2","t":3}
+				]`))
+				req.SetBasicAuth("secret", "")
+				w := httptest.NewRecorder()
+				listener.ServeHTTP(w, req)
+				return w.Code
+			}
+
+			if code := post(); code != http.StatusInternalServerError {
+				t.Errorf("first delivery: got %d, want %d", code, http.StatusInternalServerError)
+			}
+			if len(sink.Letters()) != 0 {
+				t.Errorf("first delivery: got %d dead letters, want none", len(sink.Letters()))
+			}
+			if code := post(); code != http.StatusOK {
+				t.Errorf("second delivery: got %d, want %d", code, http.StatusOK)
+			}
+			letters := sink.Letters()
+			if len(letters) != 1 || letters[0].Event.URL != "https://orders/poison" || letters[0].Err == nil {
+				t.Fatalf("second delivery: got dead letters %+v", letters)
+			}
+			want := []string{"https://orders/1", "https://orders/2", "https://orders/1", "https://orders/2"}
+			if !reflect.DeepEqual(handled, want) {
+				t.Errorf("handled: got %v, want %v", handled, want)
+			}
+		})
+	}
+}
+
+func TestListenerDeadLetterSinkError(t *testing.T) {
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error { return errors.New("failed") },
+		OnError: func(error) {},
+		UUID:    "secret",
+		DeadLetter: &DeadLetterConfig{
+			Sink: DeadLetterFunc(func(context.Context, *ReceivedEvent, error) error {
+				return errors.New("sink unavailable")
+			}),
+			MaxFailures: 1,
+		},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(
+		`[{"topic":"orders","type":"update","url":"https://orders/1","t":1}]`))
+	req.SetBasicAuth("secret", "")
+	w := httptest.NewRecorder()
+	listener.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestFileDeadLetterSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	sink, err := NewFileDeadLetterSink(path)
+	must(err)
+	for _, url := range []string{"https://orders/1", "https://orders/2"} {
+		must(sink.DeadLetter(context.Background(), &ReceivedEvent{Topic: "orders", Type: "update", URL: url}, errors.New("failed")))
+	}
+	must(sink.Close())
+
+	f, err := os.Open(path)
+	must(err)
+	defer f.Close()
+	var urls []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var letter struct {
+			Event *ReceivedEvent `json:"event"`
+			Error string         `json:"error"`
+		}
+		must(json.Unmarshal(scanner.Bytes(), &letter))
+		if letter.Error != "failed" {
+			t.Errorf("error: got %q, want %q", letter.Error, "failed")
+		}
+		urls = append(urls, letter.Event.URL)
+	}
+	if want := []string{"https://orders/1", "https://orders/2"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("got %v, want %v", urls, want)
+	}
+}
+
+func TestPushDeadLetterSink(t *testing.T) {
+	var (
+		path string
+		body map[string]interface{}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		b, _ := ioutil.ReadAll(r.Body)
+		must(json.Unmarshal(b, &body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	client, err := NewClient(&Config{URL: ts.URL, UUID: "demo"})
+	must(err)
+
+	sink := PushDeadLetterSink(client, "orders-dead")
+	must(sink.DeadLetter(context.Background(), &ReceivedEvent{
+		Topic:     "orders",
+		Type:      "update",
+		URL:       "https://orders/1",
+		Timestamp: 123,
+		Data:      json.RawMessage(`{"id":1}`),
+	}, errors.New("failed")))
+
+	if path != "/topics/orders-dead" {
+		t.Errorf("path: got %s, want %s", path, "/topics/orders-dead")
+	}
+	want := map[string]interface{}{
+		"type":      "update",
+		"url":       "https://orders/1",
+		"timestamp": float64(123),
+		"data": map[string]interface{}{
+			"topic": "orders",
+			"data":  map[string]interface{}{"id": float64(1)},
+			"error": "failed",
+		},
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("body: got %v, want %v", body, want)
+	}
+}
@@ -0,0 +1,81 @@
+package routemaster
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultDedupSize is the number of keys held by a MemoryDedupStore created
+// with a size of zero or less.
+const defaultDedupSize = 10000
+
+// A DedupStore records the keys of events that have been handled, so that a
+// Listener can drop the duplicates delivered by the bus. Implementations must
+// be safe for concurrent use.
+type DedupStore interface {
+	// Seen reports whether the key has been recorded.
+	Seen(key string) bool
+
+	// MarkSeen records the key.
+	MarkSeen(key string)
+}
+
+// DefaultDedupKey identifies an event by its topic, type, URL and timestamp.
+func DefaultDedupKey(e *ReceivedEvent) string {
+	return fmt.Sprintf("%s|%s|%s|%d", e.Topic, e.Type, e.URL, e.Timestamp)
+}
+
+// MemoryDedupStore is a DedupStore holding a bounded number of keys in memory.
+// When full, the least recently seen key is evicted. Keys also expire after a
+// fixed time.
+type MemoryDedupStore struct {
+	keys *lruCache[struct{}]
+}
+
+// NewMemoryDedupStore creates a MemoryDedupStore holding up to size keys, each
+// for at most ttl. A size of zero or less defaults to 10000 keys. A zero ttl
+// means keys only expire through eviction.
+func NewMemoryDedupStore(size int, ttl time.Duration) *MemoryDedupStore {
+	if size <= 0 {
+		size = defaultDedupSize
+	}
+	return &MemoryDedupStore{keys: newLRUCache[struct{}](size, ttl)}
+}
+
+// Seen implements DedupStore.
+func (s *MemoryDedupStore) Seen(key string) bool {
+	_, ok := s.keys.get(key)
+	return ok
+}
+
+// MarkSeen implements DedupStore.
+func (s *MemoryDedupStore) MarkSeen(key string) {
+	s.keys.set(key, struct{}{})
+}
+
+// Len returns the number of keys held, including expired ones not yet
+// evicted.
+func (s *MemoryDedupStore) Len() int {
+	return s.keys.len()
+}
+
+// dropDuplicates returns the events whose key has not been seen, along with
+// their keys. Events repeated within the batch are only kept once.
+func (l *Listener) dropDuplicates(events []*ReceivedEvent) ([]*ReceivedEvent, []string) {
+	var (
+		kept  = events[:0:0]
+		keys  []string
+		batch = make(map[string]bool, len(events))
+	)
+	for _, e := range events {
+		key := l.dedupKey(e)
+		if batch[key] || l.dedup.Seen(key) {
+			l.duplicates.Add(1)
+			continue
+		}
+		batch[key] = true
+		kept = append(kept, e)
+		keys = append(keys, key)
+	}
+	return kept, keys
+}
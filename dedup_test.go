@@ -0,0 +1,102 @@
+package routemaster
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupStore(t *testing.T) {
+	t.Run("eviction", func(t *testing.T) {
+		s := NewMemoryDedupStore(2, 0)
+		s.MarkSeen("a")
+		s.MarkSeen("b")
+		s.Seen("a")
+		s.MarkSeen("c")
+		if !s.Seen("a") || s.Seen("b") || !s.Seen("c") {
+			t.Error("expected least recently seen key to be evicted")
+		}
+		if got := s.Len(); got != 2 {
+			t.Errorf("len: got %d, want %d", got, 2)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		s := NewMemoryDedupStore(10, time.Millisecond)
+		s.MarkSeen("a")
+		if !s.Seen("a") {
+			t.Error("expected key to be seen")
+		}
+		time.Sleep(5 * time.Millisecond)
+		if s.Seen("a") {
+			t.Error("expected key to expire")
+		}
+	})
+	t.Run("default size", func(t *testing.T) {
+		for _, size := range []int{0, -1} {
+			s := NewMemoryDedupStore(size, 0)
+			s.MarkSeen("a")
+			s.MarkSeen("b")
+			if !s.Seen("a") || !s.Seen("b") {
+				t.Errorf("size %d: expected keys to be seen", size)
+			}
+		}
+	})
+}
+
+func TestListenerDedup(t *testing.T) {
+	var (
+		received []*ReceivedEvent
+		fail     bool
+	)
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error {
+			if fail {
+				return errors.New("failed")
+			}
+			received = append(received, events...)
+			return nil
+		},
+		OnError: func(error) {},
+		UUID:    "secret",
+		Dedup:   NewMemoryDedupStore(100, time.Minute),
+	})
+	post := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		req.SetBasicAuth("secret", "")
+		w := httptest.NewRecorder()
+		listener.ServeHTTP(w, req)
+		return w.Code
+	}
+	const (
+		first  = `{"topic": "orders", "type": "create", "url": "https://orders/1", "t": 1}`
+		second = `{"topic": "orders", "type": "update", "url": "https://orders/1", "t": 2}`
+	)
+
+	// Failed batches are not recorded.
+	fail = true
+	if code := post("[" + first + "]"); code != http.StatusInternalServerError {
+		t.Fatalf("status: got %d, want %d", code, http.StatusInternalServerError)
+	}
+	fail = false
+
+	if code := post("[" + first + "," + first + "]"); code != http.StatusOK {
+		t.Fatalf("status: got %d, want %d", code, http.StatusOK)
+	}
+	if code := post("[" + first + "," + second + "]"); code != http.StatusOK {
+		t.Fatalf("status: got %d, want %d", code, http.StatusOK)
+	}
+	if code := post("[" + second + "]"); code != http.StatusOK {
+		t.Fatalf("status: got %d, want %d", code, http.StatusOK)
+	}
+
+	if len(received) != 2 || received[0].Type != "create" || received[1].Type != "update" {
+		t.Errorf("received: got %d events, want create then update", len(received))
+	}
+	if got := listener.DuplicatesDropped(); got != 3 {
+		t.Errorf("duplicates dropped: got %d, want %d", got, 3)
+	}
+}
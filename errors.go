@@ -0,0 +1,123 @@
+package routemaster
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Sentinel errors matched by the errors returned from Client methods, for use
+// with errors.Is.
+var (
+	// ErrUnauthorized matches responses with status 401 Unauthorized.
+	ErrUnauthorized = errors.New("routemaster: unauthorized")
+
+	// ErrNotFound matches responses with status 404 Not Found.
+	ErrNotFound = errors.New("routemaster: not found")
+
+	// ErrRateLimited matches responses with status 429 Too Many Requests.
+	ErrRateLimited = errors.New("routemaster: rate limited")
+
+	// ErrValidation matches *ValidationError, as well as responses with
+	// status 400 Bad Request or 422 Unprocessable Entity.
+	ErrValidation = errors.New("routemaster: validation failed")
+)
+
+// excludedHeaders satisfies the API of http.Header.WriteSubset.
+var excludedHeaders = map[string]bool{"Authorization": true}
+
+// APIError is returned when the bus responds with an unsuccessful status
+// code.
+type APIError struct {
+	status      string
+	statusCode  int
+	reqBody     []byte
+	reqHeaders  string
+	respBody    string
+	respHeaders http.Header
+}
+
+// ResponseBody returns the body of the response.
+func (e *APIError) ResponseBody() string {
+	return e.respBody
+}
+
+// HTTPStatusCode returns the status code of the response.
+func (e *APIError) HTTPStatusCode() int {
+	return e.statusCode
+}
+
+// ResponseHeaders returns the headers of the response.
+func (e *APIError) ResponseHeaders() http.Header {
+	return e.respHeaders
+}
+
+// RequestBody returns the body of the request.
+func (e *APIError) RequestBody() []byte {
+	return e.reqBody
+}
+
+// RequestHeaders returns the headers of the request, without the
+// Authorization header.
+func (e *APIError) RequestHeaders() string {
+	return e.reqHeaders
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("routemaster/client: status=%s response=%s request=%s respHeaders=%v requestHeaders=%v",
+		e.status,
+		e.respBody,
+		string(e.reqBody),
+		e.respHeaders,
+		string(e.reqHeaders),
+	)
+}
+
+// Is reports whether the error matches the sentinel error corresponding to
+// its status code.
+func (e *APIError) Is(target error) bool {
+	switch e.statusCode {
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return target == ErrValidation
+	}
+	return false
+}
+
+// NetworkError is returned when a request to the bus fails before a response
+// is received.
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("routemaster/client: %v", e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError is returned when a Config, Event or Subscription is
+// invalid.
+type ValidationError struct {
+	// Field is the name of the invalid field.
+	Field string
+
+	// Message describes the problem with the field.
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("routemaster: %s %s", e.Field, e.Message)
+}
+
+// Is reports whether target is ErrValidation.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
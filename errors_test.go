@@ -0,0 +1,76 @@
+package routemaster
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	t.Run("api errors", func(t *testing.T) {
+		tests := []struct {
+			status int
+			want   error
+		}{
+			{http.StatusUnauthorized, ErrUnauthorized},
+			{http.StatusNotFound, ErrNotFound},
+			{http.StatusTooManyRequests, ErrRateLimited},
+			{http.StatusBadRequest, ErrValidation},
+			{http.StatusUnprocessableEntity, ErrValidation},
+		}
+		for _, tt := range tests {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte("oops"))
+			}))
+			client, err := NewClient(&Config{URL: ts.URL, UUID: "demo"})
+			must(err)
+
+			err = client.DeleteTopic("orders")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("status %d: got %v, want %v", tt.status, err, tt.want)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode() != tt.status || apiErr.ResponseBody() != "oops" {
+				t.Errorf("status %d: got %v, want APIError", tt.status, err)
+			}
+			ts.Close()
+		}
+	})
+
+	t.Run("network error", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		ts.Close()
+		client, err := NewClient(&Config{URL: ts.URL, UUID: "demo"})
+		must(err)
+
+		_, err = client.GetTopics()
+		var netErr *NetworkError
+		if !errors.As(err, &netErr) {
+			t.Errorf("got %v, want NetworkError", err)
+		}
+		if errors.Is(err, ErrValidation) {
+			t.Error("network error matches ErrValidation")
+		}
+	})
+
+	t.Run("validation errors", func(t *testing.T) {
+		_, err := NewClient(&Config{URL: "https://routemaster.dev"})
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Field != "UUID" {
+			t.Errorf("config: got %v, want ValidationError for UUID", err)
+		}
+
+		client, err := NewClient(&Config{URL: "https://routemaster.dev", UUID: "demo"})
+		must(err)
+		err = client.Push("orders", &Event{Type: "created", URL: "https://orders/1"})
+		if !errors.As(err, &validationErr) || validationErr.Field != "Type" || !errors.Is(err, ErrValidation) {
+			t.Errorf("event: got %v, want ValidationError for Type", err)
+		}
+		err = client.Subscribe(&Subscription{Topics: []string{"orders"}, Callback: "/events", UUID: "demo"})
+		if !errors.As(err, &validationErr) || validationErr.Field != "Callback" {
+			t.Errorf("subscription: got %v, want ValidationError for Callback", err)
+		}
+	})
+}
@@ -0,0 +1,212 @@
+package routemaster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults applied to the zero fields of a FetcherConfig.
+const (
+	defaultFetchCacheSize   = 1000
+	defaultFetchConcurrency = 4
+)
+
+// Entity is the resource an event refers to, as fetched from its URL.
+type Entity struct {
+	// URL is the URL the entity was fetched from.
+	URL string
+
+	// Deleted is set if the event is a delete event, or the URL responded
+	// with 404 Not Found or 410 Gone. Body is then empty.
+	Deleted bool
+
+	// Header holds the response headers.
+	Header http.Header
+
+	// Body is the response body.
+	Body []byte
+
+	// fetchedAt is when the entity was fetched, used to tell whether a
+	// cached copy predates an event.
+	fetchedAt time.Time
+}
+
+// Decode unmarshals the JSON body of the entity into v.
+func (e *Entity) Decode(v interface{}) error {
+	return json.Unmarshal(e.Body, v)
+}
+
+// FetchError is returned when an entity's URL responds with an unexpected
+// status code.
+type FetchError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("routemaster: fetching %s: status=%d response=%s", e.URL, e.StatusCode, e.Body)
+}
+
+// FetcherConfig specifies the way a Fetcher should be set up.
+type FetcherConfig struct {
+	// HTTPClient is used to fetch entities. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Authorize is called with every request before it is sent, to add
+	// credentials. Optional.
+	Authorize func(*http.Request)
+
+	// CacheSize is the number of entities held in the cache. Defaults to
+	// 1000; a negative value disables caching.
+	CacheSize int
+
+	// CacheTTL is how long entities are cached for. Defaults to no expiry.
+	CacheTTL time.Duration
+
+	// Concurrency is the number of entities fetched at once by
+	// FetchingHandler. Defaults to 4.
+	Concurrency int
+}
+
+// A Fetcher resolves events to the entities they refer to, by fetching their
+// URL.
+//
+// Fetched entities are cached by URL. A cached entity is only reused for
+// events that occurred before it was fetched, so that the entity reflects at
+// least the change the event notifies about.
+type Fetcher struct {
+	client      *http.Client
+	authorize   func(*http.Request)
+	cache       *lruCache[*Entity]
+	concurrency int
+}
+
+// NewFetcher creates a Fetcher.
+func NewFetcher(cfg *FetcherConfig) *Fetcher {
+	f := &Fetcher{
+		client:      cfg.HTTPClient,
+		authorize:   cfg.Authorize,
+		concurrency: cfg.Concurrency,
+	}
+	if f.client == nil {
+		f.client = http.DefaultClient
+	}
+	if f.concurrency <= 0 {
+		f.concurrency = defaultFetchConcurrency
+	}
+	switch {
+	case cfg.CacheSize == 0:
+		f.cache = newLRUCache[*Entity](defaultFetchCacheSize, cfg.CacheTTL)
+	case cfg.CacheSize > 0:
+		f.cache = newLRUCache[*Entity](cfg.CacheSize, cfg.CacheTTL)
+	}
+	return f
+}
+
+// Fetch returns the entity an event refers to. Delete events resolve to a
+// deleted entity without a request being made.
+func (f *Fetcher) Fetch(ctx context.Context, e *ReceivedEvent) (*Entity, error) {
+	if e.Type == "delete" {
+		return &Entity{URL: e.URL, Deleted: true}, nil
+	}
+	if f.cache != nil {
+		occurred := time.Unix(0, e.Timestamp*int64(time.Millisecond))
+		if entity, ok := f.cache.get(e.URL); ok && !entity.fetchedAt.Before(occurred) {
+			return entity, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if f.authorize != nil {
+		f.authorize(req)
+	}
+	fetchedAt := time.Now()
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	entity := &Entity{URL: e.URL, Header: resp.Header, fetchedAt: fetchedAt}
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		entity.Deleted = true
+	case resp.StatusCode == http.StatusOK:
+		if entity.Body, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	default:
+		body := &strings.Builder{}
+		_, _ = io.Copy(body, resp.Body)
+		return nil, &FetchError{URL: e.URL, StatusCode: resp.StatusCode, Body: body.String()}
+	}
+	if f.cache != nil {
+		f.cache.set(e.URL, entity)
+	}
+	return entity, nil
+}
+
+// FetchedEvent is a received event along with the entity it refers to.
+type FetchedEvent struct {
+	*ReceivedEvent
+
+	// Entity is the entity fetched from the event's URL.
+	Entity *Entity
+}
+
+// FetchingHandler adapts a handler of fetched events into a
+// ContextHandlerFunc. The entities of a batch are fetched concurrently before
+// h is invoked; if any of them cannot be fetched, h is not invoked and the
+// first error is returned. Fetches are cancelled along with the context of
+// the delivery.
+func FetchingHandler(f *Fetcher, h func(context.Context, []*FetchedEvent) error) ContextHandlerFunc {
+	return func(ctx context.Context, events []*ReceivedEvent) error {
+		fetchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var (
+			fetched  = make([]*FetchedEvent, len(events))
+			sem      = make(chan struct{}, f.concurrency)
+			inFlight sync.WaitGroup
+			once     sync.Once
+			firstErr error
+		)
+		for i, e := range events {
+			sem <- struct{}{}
+			inFlight.Add(1)
+			go func(i int, e *ReceivedEvent) {
+				defer func() {
+					<-sem
+					inFlight.Done()
+				}()
+				entity, err := f.Fetch(fetchCtx, e)
+				if err != nil {
+					// Later errors are most likely caused by the cancellation.
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+				fetched[i] = &FetchedEvent{ReceivedEvent: e, Entity: entity}
+			}(i, e)
+		}
+		inFlight.Wait()
+
+		if firstErr != nil {
+			return firstErr
+		}
+		return h(ctx, fetched)
+	}
+}
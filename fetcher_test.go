@@ -0,0 +1,107 @@
+package routemaster
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetcher(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if user, _, _ := r.BasicAuth(); user != "demo" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/orders/1":
+			w.Write([]byte(`{"restaurant_id": 123}`))
+		case "/orders/2":
+			w.WriteHeader(http.StatusGone)
+		case "/orders/slow":
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	f := NewFetcher(&FetcherConfig{
+		Authorize: func(r *http.Request) { r.SetBasicAuth("demo", "") },
+	})
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	ctx := context.Background()
+
+	t.Run("fetch and cache", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		event := &ReceivedEvent{Type: "update", URL: ts.URL + "/orders/1", Timestamp: now}
+		entity, err := f.Fetch(ctx, event)
+		must(err)
+		var order testOrder
+		must(entity.Decode(&order))
+		if order.RestaurantID != 123 {
+			t.Errorf("restaurant_id: got %d, want %d", order.RestaurantID, 123)
+		}
+
+		_, err = f.Fetch(ctx, event)
+		must(err)
+		if got := atomic.LoadInt32(&requests); got != 1 {
+			t.Errorf("requests: got %d, want %d", got, 1)
+		}
+
+		// A later event invalidates the cached entity.
+		later := &ReceivedEvent{Type: "update", URL: ts.URL + "/orders/1", Timestamp: now + 60000}
+		_, err = f.Fetch(ctx, later)
+		must(err)
+		if got := atomic.LoadInt32(&requests); got != 2 {
+			t.Errorf("requests: got %d, want %d", got, 2)
+		}
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		for _, event := range []*ReceivedEvent{
+			{Type: "update", URL: ts.URL + "/orders/2"},
+			{Type: "delete", URL: ts.URL + "/orders/3"},
+		} {
+			entity, err := f.Fetch(ctx, event)
+			must(err)
+			if !entity.Deleted {
+				t.Errorf("%s: expected entity to be deleted", event.URL)
+			}
+		}
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		var received []*FetchedEvent
+		h := FetchingHandler(f, func(ctx context.Context, events []*FetchedEvent) error {
+			received = events
+			return nil
+		})
+		must(h(ctx, []*ReceivedEvent{
+			{Type: "create", URL: ts.URL + "/orders/1"},
+			{Type: "update", URL: ts.URL + "/orders/2"},
+		}))
+		if len(received) != 2 || received[0].Entity.Deleted || !received[1].Entity.Deleted {
+			t.Errorf("received: got %+v", received)
+		}
+
+		err := h(ctx, []*ReceivedEvent{{Type: "create", URL: ts.URL + "/orders/4"}})
+		var fetchErr *FetchError
+		if !errors.As(err, &fetchErr) || fetchErr.StatusCode != http.StatusInternalServerError {
+			t.Errorf("error: got %v, want FetchError with status 500", err)
+		}
+
+		// Fetches are cancelled along with the delivery.
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		err = h(timeout, []*ReceivedEvent{{Type: "create", URL: ts.URL + "/orders/slow"}})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("cancelled delivery: got %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
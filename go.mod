@@ -0,0 +1,3 @@
+module github.com/deliveroo/routemaster-client-go
+
+go 1.21
@@ -0,0 +1,139 @@
+package routemaster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// ErrListenerClosed is reported when a delivery is rejected because the
+// Listener is shutting down.
+var ErrListenerClosed = errors.New("routemaster: listener closed")
+
+// lifecycle tracks the deliveries in flight in a Listener.
+type lifecycle struct {
+	mu       sync.Mutex
+	closed   bool
+	inFlight int
+	// idle is closed whenever no delivery is in flight.
+	idle chan struct{}
+}
+
+func newLifecycle() *lifecycle {
+	idle := make(chan struct{})
+	close(idle)
+	return &lifecycle{idle: idle}
+}
+
+// begin records a new delivery, unless the Listener is shut down.
+func (lc *lifecycle) begin() bool {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.closed {
+		return false
+	}
+	if lc.inFlight == 0 {
+		lc.idle = make(chan struct{})
+	}
+	lc.inFlight++
+	return true
+}
+
+// end records the end of a delivery.
+func (lc *lifecycle) end() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.inFlight--
+	if lc.inFlight == 0 {
+		close(lc.idle)
+	}
+}
+
+// InFlight returns the number of deliveries being handled.
+func (l *Listener) InFlight() int {
+	l.lifecycle.mu.Lock()
+	defer l.lifecycle.mu.Unlock()
+	return l.lifecycle.inFlight
+}
+
+// Shutdown stops accepting deliveries, which are rejected with 503 Service
+// Unavailable so that the bus retries them, and waits for the deliveries in
+// flight to be handled. If ctx is done first, Shutdown returns the context's
+// error; the deliveries still in flight are not interrupted.
+//
+// Shutdown does not stop Run; cancel its context instead.
+func (l *Listener) Shutdown(ctx context.Context) error {
+	l.lifecycle.mu.Lock()
+	l.lifecycle.closed = true
+	idle := l.lifecycle.idle
+	l.lifecycle.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Serve serves the listener with srv on ln until ctx is done, then shuts down
+// both the listener and srv, waiting up to ShutdownTimeout for the
+// deliveries in flight. If ln is nil, srv listens on srv.Addr. If srv has no
+// Handler, the listener is used; srv serves TLS if its TLSConfig has
+// certificates (see ServerTLSConfig).
+//
+// Serve returns nil once shut down gracefully, and the error of srv
+// otherwise.
+func (l *Listener) Serve(ctx context.Context, srv *http.Server, ln net.Listener) error {
+	if srv.Handler == nil {
+		srv.Handler = l
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serve(srv, ln)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), l.shutdownTimeout)
+	defer cancel()
+	// Keep serving while the listener drains, so that new deliveries are
+	// rejected with a retryable status rather than a connection error.
+	err := l.Shutdown(shutdownCtx)
+	if srvErr := srv.Shutdown(shutdownCtx); err == nil {
+		err = srvErr
+	}
+	if serveErr := <-errc; err == nil {
+		err = serveErr
+	}
+	return err
+}
+
+// serve runs srv on ln, or on srv.Addr if ln is nil. It returns nil once srv
+// is shut down.
+func serve(srv *http.Server, ln net.Listener) error {
+	useTLS := srv.TLSConfig != nil &&
+		(len(srv.TLSConfig.Certificates) > 0 || srv.TLSConfig.GetCertificate != nil)
+	var err error
+	switch {
+	case ln == nil && useTLS:
+		err = srv.ListenAndServeTLS("", "")
+	case ln == nil:
+		err = srv.ListenAndServe()
+	case useTLS:
+		err = srv.ServeTLS(ln, "", "")
+	default:
+		err = srv.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
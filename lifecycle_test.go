@@ -0,0 +1,115 @@
+package routemaster
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestListenerShutdown(t *testing.T) {
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error {
+			close(started)
+			<-release
+			return nil
+		},
+		OnError: func(error) {},
+		UUID:    "secret",
+	})
+	post := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(
+			`[{"topic":"orders","type":"update","url":"https://orders/1","t":1}]`))
+		req.SetBasicAuth("secret", "")
+		w := httptest.NewRecorder()
+		listener.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	codes := make(chan int, 1)
+	go func() { codes <- post() }()
+	<-started
+	if got := listener.InFlight(); got != 1 {
+		t.Errorf("in flight: got %d, want %d", got, 1)
+	}
+
+	// Shutdown gives up while the delivery is in flight.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := listener.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("shutdown: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if code := post(); code != http.StatusServiceUnavailable {
+		t.Errorf("delivery during shutdown: got %d, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	// Shutdown returns once the delivery has been handled.
+	done := make(chan error, 1)
+	go func() { done <- listener.Shutdown(context.Background()) }()
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("shutdown: got %v", err)
+	}
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("delivery in flight: got %d, want %d", code, http.StatusOK)
+	}
+	if got := listener.InFlight(); got != 0 {
+		t.Errorf("in flight: got %d, want %d", got, 0)
+	}
+}
+
+func TestListenerServe(t *testing.T) {
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error {
+			close(started)
+			<-release
+			return nil
+		},
+		UUID: "secret",
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- listener.Serve(ctx, &http.Server{}, ln) }()
+
+	codes := make(chan int, 1)
+	go func() {
+		req, err := http.NewRequest(http.MethodPost, "http://"+ln.Addr().String(), bytes.NewBufferString(
+			`[{"topic":"orders","type":"update","url":"https://orders/1","t":1}]`))
+		must(err)
+		req.SetBasicAuth("secret", "")
+		resp, err := http.DefaultClient.Do(req)
+		must(err)
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+
+	// The delivery in flight completes before Serve returns.
+	select {
+	case err := <-served:
+		t.Fatalf("Serve returned with a delivery in flight: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("status: got %d, want %d", code, http.StatusOK)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve: got %v", err)
+	}
+}
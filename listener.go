@@ -1,19 +1,23 @@
 package routemaster
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"sync/atomic"
+	"time"
 )
 
 // The HandlerFunc type represents a function signature for consuming events
 // received from Routemaster.
 type HandlerFunc func([]*ReceivedEvent) error
 
+// The ContextHandlerFunc type is like HandlerFunc, but also receives the
+// context of the request delivering the events.
+type ContextHandlerFunc func(context.Context, []*ReceivedEvent) error
+
 // The onError represents a function signature for handling
 // errors emitted by the listener.
 type onError func(error)
@@ -21,28 +25,197 @@ type onError func(error)
 // ListenerConfig specifies the way a listener should be set up.
 type ListenerConfig struct {
 	Handler HandlerFunc
-	Logger  *log.Logger
 	OnError onError
-	UUID    string
+
+	// UUID is the basic auth username of deliveries, i.e. the UUID of the
+	// subscription. Deliveries are rejected with 401 if it is empty and
+	// Credentials is not set: an empty UUID no longer accepts deliveries
+	// without basic auth.
+	UUID string
+
+	// Credentials provides the credentials accepted instead of UUID, so
+	// that several can be accepted while they are rotated. Optional.
+	Credentials CredentialProvider
+
+	// OnAuth is called with the name of the credential of every
+	// authenticated delivery, e.g. to check that a credential is no longer
+	// used before retiring it. Optional. The name is also logged.
+	OnAuth func(credential string)
+
+	// Log receives a structured record for every delivery, with its status
+	// code, event count, topics, request ID and error if any: successes at
+	// debug level, client errors at warn level and failures at error level.
+	// Optional; defaults to slog.Default().
+	Log *slog.Logger
+
+	// Logger receives unstructured records if Log is nil.
+	//
+	// Deprecated: Use Log.
+	Logger *log.Logger
+
+	// ContextHandler is used instead of Handler if set.
+	ContextHandler ContextHandlerFunc
+
+	// ResultHandler is used instead of Handler and ContextHandler if set.
+	// It reports the outcome of each event, so that a failing event does not
+	// cause its whole batch to be redelivered when Repush or DeadLetter are
+	// set, or when Dedup is set to skip the events already handled.
+	ResultHandler ResultHandlerFunc
+
+	// Repush re-pushes the events to retry to their topic through the given
+	// client, so that the rest of their batch can be acknowledged.
+	// Optional.
+	//
+	// The bus then delivers re-pushed events to every subscriber of their
+	// topic again, not only to this listener, so only set Repush if all
+	// subscribers tolerate duplicates. The client must also authenticate as
+	// the publisher of the topics, i.e. the listener needs the publisher's
+	// token.
+	Repush *Client
+
+	// Dedup enables the suppression of duplicate events. Optional. Events
+	// whose key has been seen are dropped before Handler is called, and the
+	// keys of a batch are recorded once Handler has succeeded. The keys of
+	// events re-pushed through Repush are not recorded.
+	Dedup DedupStore
+
+	// DedupKey identifies events for Dedup. Defaults to DefaultDedupKey.
+	DedupKey func(*ReceivedEvent) string
+
+	// Squash enables the squashing of each batch before Handler is called.
+	// Optional. See Squash for the rules applied.
+	Squash *SquashConfig
+
+	// Metrics receives measurements of deliveries and their handling.
+	// Optional.
+	Metrics Metrics
+
+	// Partition enables the concurrent handling of the partitions of each
+	// batch, e.g. of each topic. Optional; by default Handler is invoked
+	// once per batch.
+	Partition *PartitionConfig
+
+	// Async makes the listener acknowledge deliveries as soon as they are
+	// queued, and handle them when Run is called. Optional; by default
+	// deliveries are acknowledged once Handler has returned.
+	Async *AsyncConfig
+
+	// DeadLetter enables the dead-lettering of events that keep failing, so
+	// that they do not block the rest of their batch. Optional.
+	DeadLetter *DeadLetterConfig
+
+	// MaxBodySize is the maximum size of a delivery in bytes; larger
+	// deliveries are rejected with 413 Request Entity Too Large. Defaults to
+	// 10 MiB; a negative value disables the limit.
+	MaxBodySize int64
+
+	// ShutdownTimeout is how long Serve waits for deliveries in flight when
+	// shutting down. Defaults to 30s.
+	ShutdownTimeout time.Duration
 }
 
 // A Listener is an implementation of http.Handler that handles Routemaster
 // events.
 type Listener struct {
-	handler HandlerFunc
-	logger  *log.Logger
-	onError onError
-	uuid    string
+	handler    ResultHandlerFunc
+	logger     *slog.Logger
+	onError    onError
+	onAuth     func(string)
+	dedup      DedupStore
+	dedupKey   func(*ReceivedEvent) string
+	duplicates atomic.Int64
+
+	// squash is set if batches are squashed. squashTopics is nil if the
+	// events of all topics are squashed.
+	squash       bool
+	squashTopics map[string]bool
+
+	metrics Metrics
+
+	// credentials is nil if staticCredentials, set from UUID, are used.
+	credentials       CredentialProvider
+	staticCredentials []Credential
+
+	// async is nil for synchronous listeners.
+	async *AsyncConfig
+
+	deadLetter *deadLetterer
+	repush     *Client
+
+	// adapted is set if the handler fails batches as a whole.
+	adapted bool
+
+	maxBodySize int64
+
+	lifecycle       *lifecycle
+	shutdownTimeout time.Duration
 }
 
 // NewListener creates a new handler for receiving Routemaster events.
 func NewListener(cfg *ListenerConfig) *Listener {
-	return &Listener{
-		handler: cfg.Handler,
-		logger:  defaultLogger(cfg.Logger),
-		onError: cfg.OnError,
-		uuid:    cfg.UUID,
+	l := &Listener{
+		handler:  cfg.ResultHandler,
+		repush:   cfg.Repush,
+		logger:   defaultLogger(cfg.Log, cfg.Logger),
+		onError:  cfg.OnError,
+		onAuth:   cfg.OnAuth,
+		dedup:    cfg.Dedup,
+		dedupKey: cfg.DedupKey,
+		metrics:  defaultMetrics(cfg.Metrics),
+
+		credentials:       cfg.Credentials,
+		staticCredentials: []Credential{{Name: defaultCredentialName, Secret: cfg.UUID}},
+
+		maxBodySize: cfg.MaxBodySize,
+
+		lifecycle:       newLifecycle(),
+		shutdownTimeout: cfg.ShutdownTimeout,
+	}
+	if l.maxBodySize == 0 {
+		l.maxBodySize = defaultMaxBodySize
 	}
+	if l.shutdownTimeout <= 0 {
+		l.shutdownTimeout = defaultShutdownTimeout
+	}
+	if l.handler == nil {
+		h := cfg.ContextHandler
+		if h == nil {
+			handler := cfg.Handler
+			h = func(_ context.Context, events []*ReceivedEvent) error {
+				return handler(events)
+			}
+		}
+		l.handler = AdaptHandler(h)
+		l.adapted = true
+	}
+	if cfg.Async != nil {
+		l.async = newAsync(cfg.Async)
+	}
+	if cfg.DeadLetter != nil {
+		l.deadLetter = newDeadLetterer(cfg.DeadLetter)
+	}
+	if cfg.Partition != nil {
+		l.handler = partitioned(l.handler, cfg.Partition)
+	}
+	if l.dedupKey == nil {
+		l.dedupKey = DefaultDedupKey
+	}
+	if cfg.Squash != nil {
+		l.squash = true
+		if len(cfg.Squash.Topics) > 0 {
+			l.squashTopics = make(map[string]bool)
+			for _, topic := range cfg.Squash.Topics {
+				l.squashTopics[topic] = true
+			}
+		}
+	}
+	return l
+}
+
+// DuplicatesDropped returns the number of duplicate events dropped since the
+// listener was created.
+func (l *Listener) DuplicatesDropped() int64 {
+	return l.duplicates.Load()
 }
 
 // reportError replies to the request with the specified HTTP code, and a simple
@@ -54,7 +227,7 @@ func (l *Listener) reportError(w http.ResponseWriter, code int, err error) {
 	// intended status code back.
 	defer func() {
 		if r := recover(); r != nil {
-			l.logger.Printf("panic while running error handler: %+v", r)
+			l.logger.Error("panic while running error handler", slog.Any("panic", r))
 			if !wroteError {
 				text := fmt.Sprintf("%d %s", code, http.StatusText(code))
 				http.Error(w, text, code)
@@ -72,51 +245,164 @@ func (l *Listener) reportError(w http.ResponseWriter, code int, err error) {
 }
 
 func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	var (
+		events     []*ReceivedEvent
+		failure    error
+		credential string
+	)
+	defer func() {
+		l.metrics.ListenerDelivery(rec.status, len(events), time.Since(start))
+		l.logDelivery(r, rec.status, credential, events, failure, time.Since(start))
+	}()
+	fail := func(code int, err error) {
+		failure = err
+		l.reportError(w, code, err)
+	}
+
 	defer func() {
 		if r := recover(); r != nil {
 			err, ok := r.(error)
 			if !ok {
 				err = fmt.Errorf("%+v", r)
 			}
-			l.reportError(w, http.StatusInternalServerError, err)
+			fail(http.StatusInternalServerError, err)
 			return
 		}
 	}()
 
-	// Check for the expected username.
-	username, _, _ := r.BasicAuth()
-	if username != l.uuid {
-		l.reportError(w, http.StatusUnauthorized, errors.New("bad token"))
+	// Reject deliveries once shut down.
+	if !l.lifecycle.begin() {
+		fail(http.StatusServiceUnavailable, ErrListenerClosed)
+		return
+	}
+	defer l.lifecycle.end()
+
+	// Check for an accepted username.
+	credential, err := l.authenticate(r)
+	if err == errBadToken {
+		l.metrics.ListenerAuthFailure()
+		fail(http.StatusUnauthorized, err)
 		return
 	}
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	if l.onAuth != nil {
+		l.onAuth(credential)
+	}
 
-	// Read the request body.
-	b, err := ioutil.ReadAll(r.Body)
+	// Decode received events as the request body is read.
 	defer r.Body.Close()
+	decoded, code, err := l.readEvents(w, r)
 	if err != nil {
-		l.reportError(w, http.StatusInternalServerError, errors.New("request body read failed"))
+		fail(code, err)
 		return
 	}
+	events = decoded
+	for topic, count := range countByTopic(events) {
+		l.metrics.ListenerEvents(topic, count)
+	}
 
-	// Unmarshal received events.
-	var events []*ReceivedEvent
-	err = json.Unmarshal(b, &events)
-	if err != nil || len(events) == 0 {
-		l.reportError(w, http.StatusBadRequest, fmt.Errorf("body malformed: %s", string(b)))
+	// Queue events to be handled asynchronously.
+	if l.async != nil {
+		if err := l.async.Queue.Enqueue(r.Context(), events); err != nil {
+			fail(http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
+	if err := l.handle(r.Context(), events); err != nil {
+		fail(http.StatusInternalServerError, err)
+	}
+}
+
+// handle drops duplicates from a batch, squashes it and invokes the handler
+// with the remaining events. Events to retry are dead-lettered or re-pushed
+// if configured; it returns an error if any are left to be redelivered.
+func (l *Listener) handle(ctx context.Context, events []*ReceivedEvent) error {
+	// Drop events that have already been handled.
+	var keys []string
+	if l.dedup != nil {
+		events, keys = l.dropDuplicates(events)
+	}
+
+	// Only keep the latest event of each entity.
+	if l.squash {
+		events = squash(events, l.squashTopics)
+	}
+
 	// Finally, handle events.
-	if err := l.handler(events); err != nil {
-		l.reportError(w, http.StatusInternalServerError, err)
-		return
+	if len(events) > 0 {
+		var results []EventResult
+		if l.deadLetter != nil {
+			results = l.tryInvoke(ctx, events)
+			if l.adapted && len(events) > 1 && resultsError(results) != nil {
+				results = l.isolate(ctx, events)
+			}
+		} else {
+			results = l.invoke(ctx, events)
+		}
+		done, err := l.resolve(ctx, events, results)
+		if err != nil {
+			// Record the events done with, so that they are dropped when the
+			// batch is redelivered.
+			if l.dedup != nil {
+				for i, e := range events {
+					if done[i] {
+						l.dedup.MarkSeen(l.dedupKey(e))
+					}
+				}
+			}
+			return err
+		}
+		// Re-pushed events are not recorded, so that they are handled when
+		// the bus delivers them again.
+		if l.dedup != nil {
+			repushed := make(map[string]bool)
+			for i, e := range events {
+				if !done[i] {
+					repushed[l.dedupKey(e)] = true
+				}
+			}
+			kept := keys[:0]
+			for _, key := range keys {
+				if !repushed[key] {
+					kept = append(kept, key)
+				}
+			}
+			keys = kept
+		}
+	}
+	for _, key := range keys {
+		l.dedup.MarkSeen(key)
 	}
+	return nil
+}
+
+// invoke calls the handler, measuring its duration.
+func (l *Listener) invoke(ctx context.Context, events []*ReceivedEvent) (results []EventResult) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			l.metrics.ListenerHandler(time.Since(start), fmt.Errorf("panic: %+v", r))
+			panic(r)
+		}
+		l.metrics.ListenerHandler(time.Since(start), resultsError(results))
+	}()
+	return checkResults(events, l.handler(ctx, events))
 }
 
-// defaultLogger returns a logger if the given one is nil.
-func defaultLogger(logger *log.Logger) *log.Logger {
-	if logger != nil {
-		return logger
+// countByTopic returns the number of events of each topic.
+func countByTopic(events []*ReceivedEvent) map[string]int {
+	counts := make(map[string]int)
+	for _, e := range events {
+		counts[e.Topic]++
 	}
-	return log.New(os.Stderr, "routemaster/client: ", log.LstdFlags)
+	return counts
 }
@@ -0,0 +1,145 @@
+package routemaster
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// requestIDHeader is the header carrying the ID of a request, reported in log
+// records when present.
+const requestIDHeader = "X-Request-Id"
+
+// defaultLogger returns the structured logger of a Listener given the loggers
+// of its configuration. A legacy *log.Logger is adapted to emit text records.
+func defaultLogger(logger *slog.Logger, legacy *log.Logger) *slog.Logger {
+	if logger != nil {
+		return logger
+	}
+	if legacy != nil {
+		return slog.New(slog.NewTextHandler(logWriter{legacy}, &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				// The legacy logger adds its own timestamp.
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}))
+	}
+	return slog.Default()
+}
+
+// clientLogger returns a logger discarding all records if the given one is
+// nil.
+func clientLogger(logger *slog.Logger) *slog.Logger {
+	if logger != nil {
+		return logger
+	}
+	return slog.New(discardHandler{})
+}
+
+// logWriter writes every record to a *log.Logger.
+type logWriter struct {
+	logger *log.Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	if err := w.logger.Output(2, strings.TrimSuffix(string(p), "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// discardHandler is a slog.Handler discarding all records.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// levelOf returns the level of the record of a response with the given
+// status code. Successful deliveries are logged at debug level, so that the
+// default logger only reports failures.
+func levelOf(statusCode int) slog.Level {
+	switch {
+	case statusCode >= 500:
+		return slog.LevelError
+	case statusCode >= 400:
+		return slog.LevelWarn
+	default:
+		return slog.LevelDebug
+	}
+}
+
+// logDelivery records the outcome of a delivery to a Listener. credential is
+// the name of the credential of the delivery, if authenticated; err is the
+// error reported to the bus, if any.
+func (l *Listener) logDelivery(r *http.Request, statusCode int, credential string, events []*ReceivedEvent, err error, duration time.Duration) {
+	attrs := []slog.Attr{
+		slog.Int("status", statusCode),
+		slog.Int("events", len(events)),
+		slog.Duration("duration", duration),
+	}
+	if credential != "" {
+		attrs = append(attrs, slog.String("credential", credential))
+	}
+	if len(events) > 0 {
+		topics := make([]string, 0, 1)
+		for topic := range countByTopic(events) {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+		attrs = append(attrs, slog.Any("topics", topics))
+	}
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	l.logger.LogAttrs(r.Context(), levelOf(statusCode), "routemaster delivery", attrs...)
+}
+
+// logAttempt records the outcome of an attempt at a request made by a
+// Client. retryIn is the delay before the next attempt, if retry is set. The
+// route of the request is logged rather than its path, which may hold an API
+// token.
+func (c *Client) logAttempt(ctx context.Context, method, path string, attempt int, resp *http.Response, err error, duration time.Duration, retry bool, retryIn time.Duration) {
+	route := routeOf(path)
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("route", route),
+		slog.Int("attempt", attempt),
+		slog.Duration("duration", duration),
+	}
+	if strings.HasSuffix(route, "/{topic}") {
+		attrs = append(attrs, slog.String("topic", path[strings.LastIndex(path, "/")+1:]))
+	}
+	level := slog.LevelDebug
+	if resp != nil {
+		attrs = append(attrs, slog.Int("status", resp.StatusCode))
+		if id := resp.Header.Get(requestIDHeader); id != "" {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+		if !isHTTPSuccess(resp.StatusCode) {
+			level = slog.LevelError
+		}
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+		level = slog.LevelError
+	}
+	msg := "routemaster request"
+	if retry {
+		attrs = append(attrs, slog.Duration("retry_in", retryIn))
+		level = slog.LevelWarn
+		msg = "routemaster request failed, retrying"
+	}
+	c.logger.LogAttrs(ctx, level, msg, attrs...)
+}
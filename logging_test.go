@@ -0,0 +1,224 @@
+package routemaster
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readRecords decodes the JSON records written to buf, dropping their time
+// and duration.
+func readRecords(buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var record map[string]interface{}
+		must(dec.Decode(&record))
+		delete(record, "time")
+		delete(record, "duration")
+		records = append(records, record)
+	}
+	buf.Reset()
+	return records
+}
+
+func TestListenerLogging(t *testing.T) {
+	var (
+		buf  bytes.Buffer
+		fail bool
+	)
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error {
+			if fail {
+				return errors.New("failed")
+			}
+			return nil
+		},
+		OnError: func(error) {},
+		UUID:    "secret",
+		Log:     slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	batch := `[
+		{"topic":"riders","type":"create","url":"https://riders/1","t":1},
+		{"topic":"orders","type":"create","url":"https://orders/1","t":2}
+	]`
+
+	tests := []struct {
+		name     string
+		username string
+		body     string
+		fail     bool
+		want     map[string]interface{}
+	}{
+		{
+			name:     "success",
+			username: "secret",
+			body:     batch,
+			want: map[string]interface{}{
+				"level":      "DEBUG",
+				"msg":        "routemaster delivery",
+				"status":     float64(200),
+				"events":     float64(2),
+				"topics":     []interface{}{"orders", "riders"},
+				"request_id": "abc",
+				"credential": "uuid",
+			},
+		},
+		{
+			name:     "handler error",
+			username: "secret",
+			body:     batch,
+			fail:     true,
+			want: map[string]interface{}{
+				"level":      "ERROR",
+				"msg":        "routemaster delivery",
+				"status":     float64(500),
+				"events":     float64(2),
+				"topics":     []interface{}{"orders", "riders"},
+				"request_id": "abc",
+				"credential": "uuid",
+				"error":      "failed",
+			},
+		},
+		{
+			name:     "bad token",
+			username: "wrong",
+			body:     batch,
+			want: map[string]interface{}{
+				"level":      "WARN",
+				"msg":        "routemaster delivery",
+				"status":     float64(401),
+				"events":     float64(0),
+				"request_id": "abc",
+				"error":      "bad token",
+			},
+		},
+	}
+	for _, tt := range tests {
+		fail = tt.fail
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(tt.body))
+		req.SetBasicAuth(tt.username, "")
+		req.Header.Set("X-Request-Id", "abc")
+		listener.ServeHTTP(httptest.NewRecorder(), req)
+
+		records := readRecords(&buf)
+		if len(records) != 1 {
+			t.Errorf("%s: got %d records, want 1", tt.name, len(records))
+			continue
+		}
+		if !reflect.DeepEqual(records[0], tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, records[0], tt.want)
+		}
+	}
+}
+
+func TestListenerLoggingLevel(t *testing.T) {
+	// Successful deliveries are not logged at the default level.
+	var buf bytes.Buffer
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error { return nil },
+		UUID:    "secret",
+		Log:     slog.New(slog.NewJSONHandler(&buf, nil)),
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(
+		`[{"topic":"orders","type":"create","url":"https://orders/1","t":1}]`))
+	req.SetBasicAuth("secret", "")
+	w := httptest.NewRecorder()
+	listener.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want %d", w.Code, http.StatusOK)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("got %q, want no records", buf.String())
+	}
+}
+
+func TestListenerLegacyLogger(t *testing.T) {
+	var buf bytes.Buffer
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error { return nil },
+		Logger:  log.New(&buf, "prefix: ", 0),
+		UUID:    "secret",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("[]"))
+	listener.ServeHTTP(httptest.NewRecorder(), req)
+
+	got := buf.String()
+	if !strings.HasPrefix(got, "prefix: level=WARN msg=\"routemaster delivery\" status=401") {
+		t.Errorf("got %q", got)
+	}
+	if strings.Contains(got, "time=") {
+		t.Errorf("got %q, want no time attribute", got)
+	}
+}
+
+func TestClientLogging(t *testing.T) {
+	var attempts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("X-Request-Id", "abc")
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	client, err := NewClient(&Config{
+		URL:   ts.URL,
+		UUID:  "demo",
+		Retry: &RetryPolicy{InitialBackoff: time.Millisecond},
+		Log:   slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	must(err)
+	if err := client.DeleteTopic("orders"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	want := []map[string]interface{}{
+		{
+			"level":      "WARN",
+			"msg":        "routemaster request failed, retrying",
+			"method":     "DELETE",
+			"route":      "/topic/{topic}",
+			"topic":      "orders",
+			"attempt":    float64(1),
+			"status":     float64(503),
+			"request_id": "abc",
+			"retry_in":   float64(0),
+		},
+		{
+			"level":      "ERROR",
+			"msg":        "routemaster request",
+			"method":     "DELETE",
+			"route":      "/topic/{topic}",
+			"topic":      "orders",
+			"attempt":    float64(2),
+			"status":     float64(404),
+			"request_id": "abc",
+		},
+	}
+	if got := readRecords(&buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// API tokens are not logged.
+	_ = client.DeleteToken("s3cr3t-token")
+	if strings.Contains(buf.String(), "s3cr3t-token") {
+		t.Errorf("token logged: %s", buf.String())
+	}
+	if got := readRecords(&buf); len(got) == 0 || got[len(got)-1]["route"] != "/api_tokens/{token}" {
+		t.Errorf("token route: got %v", got)
+	}
+}
@@ -0,0 +1,85 @@
+package routemaster
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Metrics receives measurements from Clients and Listeners. Implementations
+// must be safe for concurrent use. See package routemasterprom for a
+// Prometheus implementation.
+type Metrics interface {
+	// ClientRequest is called after every attempt at a request made by a
+	// Client. route is the path template of the endpoint, e.g.
+	// /topics/{topic}. statusCode is 0 if no response was received.
+	ClientRequest(method, route string, statusCode int, duration time.Duration)
+
+	// ListenerDelivery is called after a Listener has responded to a
+	// delivery. batchSize is 0 if the batch could not be read.
+	ListenerDelivery(statusCode int, batchSize int, duration time.Duration)
+
+	// ListenerEvents is called for every topic of a delivered batch, with
+	// the number of events it holds for the topic.
+	ListenerEvents(topic string, count int)
+
+	// ListenerHandler is called after every invocation of a Listener's
+	// handler.
+	ListenerHandler(duration time.Duration, err error)
+
+	// ListenerAuthFailure is called when a Listener rejects a delivery
+	// because of bad credentials.
+	ListenerAuthFailure()
+}
+
+// NopMetrics is a Metrics discarding all measurements. It is used when no
+// Metrics are configured.
+type NopMetrics struct{}
+
+// ClientRequest implements Metrics.
+func (NopMetrics) ClientRequest(string, string, int, time.Duration) {}
+
+// ListenerDelivery implements Metrics.
+func (NopMetrics) ListenerDelivery(int, int, time.Duration) {}
+
+// ListenerEvents implements Metrics.
+func (NopMetrics) ListenerEvents(string, int) {}
+
+// ListenerHandler implements Metrics.
+func (NopMetrics) ListenerHandler(time.Duration, error) {}
+
+// ListenerAuthFailure implements Metrics.
+func (NopMetrics) ListenerAuthFailure() {}
+
+// defaultMetrics returns NopMetrics if the given metrics are nil.
+func defaultMetrics(m Metrics) Metrics {
+	if m != nil {
+		return m
+	}
+	return NopMetrics{}
+}
+
+// routeOf returns the path template of a Client request path, so that topic
+// names and tokens do not inflate the cardinality of metrics.
+func routeOf(path string) string {
+	for _, prefix := range []string{"/subscriber/topics/", "/topics/", "/topic/"} {
+		if strings.HasPrefix(path, prefix) {
+			return prefix + "{topic}"
+		}
+	}
+	if strings.HasPrefix(path, "/api_tokens/") {
+		return "/api_tokens/{token}"
+	}
+	return path
+}
+
+// statusRecorder records the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,189 @@
+package routemaster
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics records measurements as strings, ignoring durations.
+type recordingMetrics struct {
+	mu      sync.Mutex
+	records []string
+}
+
+func (m *recordingMetrics) record(format string, args ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = append(m.records, fmt.Sprintf(format, args...))
+}
+
+func (m *recordingMetrics) ClientRequest(method, route string, statusCode int, _ time.Duration) {
+	m.record("request %s %s %d", method, route, statusCode)
+}
+
+func (m *recordingMetrics) ListenerDelivery(statusCode int, batchSize int, _ time.Duration) {
+	m.record("delivery %d %d", statusCode, batchSize)
+}
+
+func (m *recordingMetrics) ListenerEvents(topic string, count int) {
+	m.record("events %s %d", topic, count)
+}
+
+func (m *recordingMetrics) ListenerHandler(_ time.Duration, err error) {
+	m.record("handler %v", err)
+}
+
+func (m *recordingMetrics) ListenerAuthFailure() {
+	m.record("auth failure")
+}
+
+func (m *recordingMetrics) reset() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	records := m.records
+	m.records = nil
+	return records
+}
+
+func TestListenerMetrics(t *testing.T) {
+	var (
+		metrics recordingMetrics
+		fail    bool
+	)
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error {
+			if fail {
+				return errors.New("failed")
+			}
+			return nil
+		},
+		OnError: func(error) {},
+		UUID:    "secret",
+		Metrics: &metrics,
+	})
+	post := func(username, body string) {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		req.SetBasicAuth(username, "")
+		listener.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	batch := `[
+		{"topic":"orders","type":"create","url":"https://orders/1","t":1},
+		{"topic":"orders","type":"update","url":"https://orders/1","t":2},
+		{"topic":"riders","type":"create","url":"https://riders/1","t":3}
+	]`
+
+	tests := []struct {
+		name     string
+		username string
+		body     string
+		fail     bool
+		want     []string
+	}{
+		{
+			name:     "success",
+			username: "secret",
+			body:     batch,
+			want: []string{
+				"events orders 2",
+				"events riders 1",
+				"handler <nil>",
+				"delivery 200 3",
+			},
+		},
+		{
+			name:     "handler error",
+			username: "secret",
+			body:     batch,
+			fail:     true,
+			want: []string{
+				"events orders 2",
+				"events riders 1",
+				"handler failed",
+				"delivery 500 3",
+			},
+		},
+		{
+			name:     "bad token",
+			username: "wrong",
+			body:     batch,
+			want:     []string{"auth failure", "delivery 401 0"},
+		},
+		{
+			name:     "malformed body",
+			username: "secret",
+			body:     "[",
+			want:     []string{"delivery 400 0"},
+		},
+	}
+	for _, tt := range tests {
+		fail = tt.fail
+		post(tt.username, tt.body)
+		got := metrics.reset()
+		// Topics are reported in no particular order.
+		if len(got) == len(tt.want) && len(got) > 2 && got[0] > got[1] {
+			got[0], got[1] = got[1], got[0]
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientMetrics(t *testing.T) {
+	var (
+		metrics  recordingMetrics
+		attempts int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(&Config{
+		URL:     ts.URL,
+		UUID:    "demo",
+		Retry:   &RetryPolicy{InitialBackoff: time.Millisecond},
+		Metrics: &metrics,
+	})
+	must(err)
+	must(client.DeleteTopic("orders"))
+
+	want := []string{
+		"request DELETE /topic/{topic} 503",
+		"request DELETE /topic/{topic} 204",
+	}
+	if got := metrics.reset(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRouteOf(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"/topics", "/topics"},
+		{"/topics/orders", "/topics/{topic}"},
+		{"/topic/orders", "/topic/{topic}"},
+		{"/subscriber/topics/orders", "/subscriber/topics/{topic}"},
+		{"/subscriber", "/subscriber"},
+		{"/api_tokens", "/api_tokens"},
+		{"/api_tokens/secret", "/api_tokens/{token}"},
+	}
+	for _, tt := range tests {
+		if got := routeOf(tt.path); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package routemaster
 
 import (
 	"encoding/json"
-	"errors"
 	"net/url"
 )
 
@@ -26,17 +25,17 @@ type Subscription struct {
 
 func (s *Subscription) validate() error {
 	if len(s.Topics) == 0 {
-		return errors.New("routemaster: at least one topic must be specified")
+		return &ValidationError{Field: "Topics", Message: "must contain at least one topic"}
 	}
 	u, err := url.Parse(s.Callback)
 	if err != nil {
-		return errors.New("routemaster: callback must be a valid URL")
+		return &ValidationError{Field: "Callback", Message: "must be a valid URL"}
 	}
 	if !u.IsAbs() {
-		return errors.New("routemaster: callback must be https")
+		return &ValidationError{Field: "Callback", Message: "must be an absolute URL"}
 	}
 	if s.UUID == "" {
-		return errors.New("routemaster: UUID must be non-empty")
+		return &ValidationError{Field: "UUID", Message: "must not be empty"}
 	}
 	return nil
 }
@@ -61,14 +60,14 @@ func (e *Event) validate() error {
 	switch e.Type {
 	case "create", "update", "delete", "noop":
 	default:
-		return errors.New("routemaster: type must be one of create, update, delete, noop")
+		return &ValidationError{Field: "Type", Message: "must be one of create, update, delete, noop"}
 	}
 	u, err := url.Parse(e.URL)
 	if err != nil {
-		return errors.New("routemaster: URL must be a valid URL")
+		return &ValidationError{Field: "URL", Message: "must be a valid URL"}
 	}
 	if !u.IsAbs() {
-		return errors.New("routemaster: URL must be https")
+		return &ValidationError{Field: "URL", Message: "must be an absolute URL"}
 	}
 	return nil
 }
@@ -0,0 +1,110 @@
+package routemaster
+
+import (
+	"errors"
+	"sync"
+)
+
+// A Mux routes received events to handlers registered per topic and event
+// type. Its ServeEvents method is a HandlerFunc, to be passed to a Listener:
+//
+//	mux := routemaster.NewMux()
+//	mux.Handle("orders", handleOrders)
+//	mux.HandleType("riders", "delete", handleDeletedRiders)
+//	listener := routemaster.NewListener(&routemaster.ListenerConfig{
+//		Handler: mux.ServeEvents,
+//		UUID:    "demo",
+//	})
+//
+// Each event is routed to the handler registered for its topic and type if
+// there is one, otherwise to the handler registered for its topic, otherwise
+// to the fallback handler. Events matching no handler are acknowledged
+// without being handled.
+type Mux struct {
+	mu       sync.RWMutex
+	handlers map[muxKey]HandlerFunc
+	fallback HandlerFunc
+}
+
+type muxKey struct {
+	topic, eventType string
+	fallback         bool
+}
+
+// NewMux creates an empty Mux.
+func NewMux() *Mux {
+	return &Mux{handlers: make(map[muxKey]HandlerFunc)}
+}
+
+// Handle registers the handler for events of any type on the given topic.
+func (m *Mux) Handle(topic string, h HandlerFunc) {
+	m.HandleType(topic, "", h)
+}
+
+// HandleType registers the handler for events of the given type (create,
+// update, delete or noop) on the given topic.
+func (m *Mux) HandleType(topic, eventType string, h HandlerFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[muxKey{topic: topic, eventType: eventType}] = h
+}
+
+// HandleFallback registers the handler for events that match no other
+// handler.
+func (m *Mux) HandleFallback(h HandlerFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fallback = h
+}
+
+// ServeEvents splits a batch by handler and invokes each handler once with
+// its events, in the order they were received.
+//
+// Every handler is invoked even if another one fails, and the errors of all
+// failing handlers are returned joined. Since a failed batch is redelivered
+// in full by the bus, handlers must be prepared to see events again after
+// they have handled them successfully.
+func (m *Mux) ServeEvents(events []*ReceivedEvent) error {
+	m.mu.RLock()
+	var (
+		order   []muxKey
+		batches = make(map[muxKey][]*ReceivedEvent)
+		targets = make(map[muxKey]HandlerFunc)
+	)
+	for _, e := range events {
+		key, h := m.match(e)
+		if h == nil {
+			continue
+		}
+		if _, ok := batches[key]; !ok {
+			order = append(order, key)
+			targets[key] = h
+		}
+		batches[key] = append(batches[key], e)
+	}
+	m.mu.RUnlock()
+
+	var errs []error
+	for _, key := range order {
+		if err := targets[key](batches[key]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// match returns the handler for an event, and the key it is registered
+// under.
+func (m *Mux) match(e *ReceivedEvent) (muxKey, HandlerFunc) {
+	if e.Type != "" {
+		key := muxKey{topic: e.Topic, eventType: e.Type}
+		if h, ok := m.handlers[key]; ok {
+			return key, h
+		}
+	}
+	key := muxKey{topic: e.Topic}
+	if h, ok := m.handlers[key]; ok {
+		return key, h
+	}
+	return muxKey{fallback: true}, m.fallback
+}
@@ -0,0 +1,72 @@
+package routemaster
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	events := []*ReceivedEvent{
+		{Topic: "orders", Type: "create", URL: "https://orders/1"},
+		{Topic: "riders", Type: "update", URL: "https://riders/1"},
+		{Topic: "orders", Type: "delete", URL: "https://orders/1"},
+		{Topic: "orders", Type: "update", URL: "https://orders/2"},
+		{Topic: "zones", Type: "update", URL: "https://zones/1"},
+	}
+
+	handled := make(map[string][]string)
+	record := func(name string, err error) HandlerFunc {
+		return func(events []*ReceivedEvent) error {
+			for _, e := range events {
+				handled[name] = append(handled[name], e.Type+" "+e.URL)
+			}
+			return err
+		}
+	}
+
+	t.Run("routing", func(t *testing.T) {
+		handled = make(map[string][]string)
+		mux := NewMux()
+		mux.Handle("orders", record("orders", nil))
+		mux.HandleType("orders", "delete", record("orders deletes", nil))
+		mux.HandleFallback(record("fallback", nil))
+		must(mux.ServeEvents(events))
+
+		want := map[string][]string{
+			"orders":         {"create https://orders/1", "update https://orders/2"},
+			"orders deletes": {"delete https://orders/1"},
+			"fallback":       {"update https://riders/1", "update https://zones/1"},
+		}
+		if !reflect.DeepEqual(handled, want) {
+			t.Errorf("handled: got %v, want %v", handled, want)
+		}
+	})
+
+	t.Run("unmatched events", func(t *testing.T) {
+		handled = make(map[string][]string)
+		mux := NewMux()
+		mux.HandleType("riders", "update", record("riders", nil))
+		must(mux.ServeEvents(events))
+
+		want := map[string][]string{"riders": {"update https://riders/1"}}
+		if !reflect.DeepEqual(handled, want) {
+			t.Errorf("handled: got %v, want %v", handled, want)
+		}
+	})
+
+	t.Run("failing handler", func(t *testing.T) {
+		handled = make(map[string][]string)
+		errOrders := errors.New("orders failed")
+		mux := NewMux()
+		mux.Handle("orders", record("orders", errOrders))
+		mux.Handle("riders", record("riders", nil))
+
+		if err := mux.ServeEvents(events); !errors.Is(err, errOrders) {
+			t.Errorf("error: got %v, want %v", err, errOrders)
+		}
+		if len(handled["riders"]) != 1 {
+			t.Errorf("riders: got %v, want handler to run", handled["riders"])
+		}
+	})
+}
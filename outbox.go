@@ -0,0 +1,273 @@
+package routemaster
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// OutboxMessage is an event stored in an outbox, waiting to be pushed.
+type OutboxMessage struct {
+	// ID uniquely identifies the message within its store.
+	ID int64
+
+	// Topic is the topic the event is pushed to.
+	Topic string
+
+	// Event is the event to push.
+	Event *Event
+
+	// Attempts is the number of failed attempts at pushing the event.
+	Attempts int
+
+	// CreatedAt is when the message was added to the outbox.
+	CreatedAt time.Time
+}
+
+// An OutboxStore persists events until a Relay has pushed them to the bus.
+//
+// Adding events is specific to each implementation, so that it can take part
+// in the transaction that stores the corresponding business data; see
+// SQLOutboxStore.Enqueue.
+type OutboxStore interface {
+	// Pending returns up to limit undelivered messages that are due for an
+	// attempt at the given time, oldest first.
+	Pending(ctx context.Context, now time.Time, limit int) ([]*OutboxMessage, error)
+
+	// MarkDelivered records that the message with the given ID was pushed.
+	MarkDelivered(ctx context.Context, id int64) error
+
+	// MarkFailed records a failed attempt at pushing the message with the
+	// given ID, and defers the next attempt until retryAt.
+	MarkFailed(ctx context.Context, id int64, retryAt time.Time) error
+}
+
+// MemoryOutboxStore is an OutboxStore that keeps messages in memory. It is
+// intended for tests.
+type MemoryOutboxStore struct {
+	mu       sync.Mutex
+	nextID   int64
+	messages []*memoryOutboxEntry
+}
+
+type memoryOutboxEntry struct {
+	msg       OutboxMessage
+	retryAt   time.Time
+	delivered bool
+}
+
+// NewMemoryOutboxStore creates an empty MemoryOutboxStore.
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{}
+}
+
+// Enqueue validates an event and adds it to the outbox.
+func (s *MemoryOutboxStore) Enqueue(topic string, e *Event) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	s.messages = append(s.messages, &memoryOutboxEntry{
+		msg: OutboxMessage{
+			ID:        s.nextID,
+			Topic:     topic,
+			Event:     e,
+			CreatedAt: time.Now(),
+		},
+	})
+	return nil
+}
+
+// Undelivered returns the number of messages not yet delivered.
+func (s *MemoryOutboxStore) Undelivered() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, m := range s.messages {
+		if !m.delivered {
+			n++
+		}
+	}
+	return n
+}
+
+// Pending implements OutboxStore.
+func (s *MemoryOutboxStore) Pending(ctx context.Context, now time.Time, limit int) ([]*OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []*OutboxMessage
+	for _, m := range s.messages {
+		if len(result) == limit {
+			break
+		}
+		if m.delivered || m.retryAt.After(now) {
+			continue
+		}
+		msg := m.msg
+		result = append(result, &msg)
+	}
+	return result, nil
+}
+
+// MarkDelivered implements OutboxStore.
+func (s *MemoryOutboxStore) MarkDelivered(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.find(id); m != nil {
+		m.delivered = true
+	}
+	return nil
+}
+
+// MarkFailed implements OutboxStore.
+func (s *MemoryOutboxStore) MarkFailed(ctx context.Context, id int64, retryAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.find(id); m != nil {
+		m.msg.Attempts++
+		m.retryAt = retryAt
+	}
+	return nil
+}
+
+func (s *MemoryOutboxStore) find(id int64) *memoryOutboxEntry {
+	for _, m := range s.messages {
+		if m.msg.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// Defaults applied to the zero fields of a RelayConfig.
+const (
+	defaultRelayBatchSize      = 100
+	defaultRelayPollInterval   = time.Second
+	defaultRelayInitialBackoff = time.Second
+	defaultRelayMaxBackoff     = 5 * time.Minute
+)
+
+// RelayConfig specifies the way a Relay should be set up.
+type RelayConfig struct {
+	// Store is the outbox to drain.
+	Store OutboxStore
+
+	// BatchSize is the maximum number of messages read from the store at
+	// once. Defaults to 100.
+	BatchSize int
+
+	// PollInterval is how long Run waits before checking the store again
+	// once it is drained. Defaults to 1s.
+	PollInterval time.Duration
+
+	// InitialBackoff is how long a message is held back after its first
+	// failed push. It doubles after every subsequent failure. Defaults to 1s.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the time a message is held back after a failed push.
+	// Defaults to 5m.
+	MaxBackoff time.Duration
+
+	// OnError is called whenever a message could not be pushed, or the store
+	// returned an error. Optional; msg is nil for store errors.
+	OnError func(msg *OutboxMessage, err error)
+}
+
+// A Relay pushes the messages of an OutboxStore to the bus.
+//
+// Delivery is at-least-once: a message is only marked as delivered after it
+// has been pushed, so it is pushed again if marking it fails, or if several
+// relays drain the same store concurrently. Messages are pushed in the order
+// the store returns them, but a failed message does not hold back the ones
+// after it.
+type Relay struct {
+	client         *Client
+	store          OutboxStore
+	batchSize      int
+	pollInterval   time.Duration
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	onError        func(*OutboxMessage, error)
+}
+
+// NewRelay creates a Relay pushing messages through the given client.
+func NewRelay(client *Client, cfg *RelayConfig) *Relay {
+	r := &Relay{
+		client:         client,
+		store:          cfg.Store,
+		batchSize:      cfg.BatchSize,
+		pollInterval:   cfg.PollInterval,
+		initialBackoff: cfg.InitialBackoff,
+		maxBackoff:     cfg.MaxBackoff,
+		onError:        cfg.OnError,
+	}
+	if r.batchSize <= 0 {
+		r.batchSize = defaultRelayBatchSize
+	}
+	if r.pollInterval <= 0 {
+		r.pollInterval = defaultRelayPollInterval
+	}
+	if r.initialBackoff <= 0 {
+		r.initialBackoff = defaultRelayInitialBackoff
+	}
+	if r.maxBackoff <= 0 {
+		r.maxBackoff = defaultRelayMaxBackoff
+	}
+	return r
+}
+
+// Run drains the outbox until ctx is done, then returns the context's error.
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.Drain(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.reportError(nil, err)
+		}
+		// Poll again straight away if the last batch was full.
+		if err == nil && n == r.batchSize {
+			continue
+		}
+		if err := sleep(ctx, r.pollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// Drain makes one attempt at pushing each message currently due, reading at
+// most one batch from the store. It returns the number of messages read.
+// Push failures are reported to OnError and rescheduled; only store errors
+// and context errors are returned.
+func (r *Relay) Drain(ctx context.Context) (int, error) {
+	msgs, err := r.store.Pending(ctx, time.Now(), r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, msg := range msgs {
+		if err := ctx.Err(); err != nil {
+			return len(msgs), err
+		}
+		if err := r.client.PushContext(ctx, msg.Topic, msg.Event); err != nil {
+			if ctx.Err() != nil {
+				return len(msgs), ctx.Err()
+			}
+			r.reportError(msg, err)
+			retryAt := time.Now().Add(exponentialBackoff(r.initialBackoff, r.maxBackoff, msg.Attempts+1))
+			if err := r.store.MarkFailed(ctx, msg.ID, retryAt); err != nil {
+				return len(msgs), err
+			}
+			continue
+		}
+		if err := r.store.MarkDelivered(ctx, msg.ID); err != nil {
+			return len(msgs), err
+		}
+	}
+	return len(msgs), nil
+}
+
+func (r *Relay) reportError(msg *OutboxMessage, err error) {
+	if r.onError != nil {
+		r.onError(msg, err)
+	}
+}
@@ -0,0 +1,198 @@
+package routemaster
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const defaultOutboxTable = "routemaster_outbox"
+
+// outboxQuarantine is the retry time of messages whose payload cannot be
+// decoded, so that they are no longer attempted. It is about the latest time
+// a MySQL TIMESTAMP can hold.
+var outboxQuarantine = time.Date(2038, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// SQLExecer is implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type SQLExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// QuestionPlaceholder formats query parameters as ?, as expected by MySQL and
+// SQLite drivers.
+func QuestionPlaceholder(n int) string {
+	return "?"
+}
+
+// DollarPlaceholder formats query parameters as $1, $2, etc., as expected by
+// PostgreSQL drivers.
+func DollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// SQLOutboxConfig specifies the way a SQLOutboxStore should be set up.
+type SQLOutboxConfig struct {
+	// DB is the database holding the outbox table.
+	DB *sql.DB
+
+	// Table is the name of the outbox table. It is interpolated into
+	// queries as is. Defaults to routemaster_outbox.
+	Table string
+
+	// Placeholder formats the nth (1-based) query parameter. Defaults to
+	// QuestionPlaceholder.
+	Placeholder func(n int) string
+
+	// OnMalformed is called with the ID of every message whose payload
+	// cannot be decoded. Such messages are quarantined rather than blocking
+	// the outbox: they are marked failed with a retry_at in 2038, and can be
+	// retried once repaired by resetting their retry_at. Optional.
+	OnMalformed func(id int64, err error)
+}
+
+// SQLOutboxStore is an OutboxStore backed by a database/sql table with the
+// following columns, adapted to the dialect of the database:
+//
+//	CREATE TABLE routemaster_outbox (
+//	    id           BIGSERIAL PRIMARY KEY,
+//	    topic        TEXT NOT NULL,
+//	    payload      TEXT NOT NULL,
+//	    attempts     INTEGER NOT NULL DEFAULT 0,
+//	    created_at   TIMESTAMP NOT NULL,
+//	    retry_at     TIMESTAMP NOT NULL,
+//	    delivered_at TIMESTAMP NULL
+//	);
+//
+// Delivered rows are kept for auditing; deleting old ones is left to the
+// application.
+type SQLOutboxStore struct {
+	db          *sql.DB
+	table       string
+	placeholder func(int) string
+	onMalformed func(int64, error)
+}
+
+// NewSQLOutboxStore creates a SQLOutboxStore.
+func NewSQLOutboxStore(cfg *SQLOutboxConfig) *SQLOutboxStore {
+	s := &SQLOutboxStore{
+		db:          cfg.DB,
+		table:       cfg.Table,
+		placeholder: cfg.Placeholder,
+		onMalformed: cfg.OnMalformed,
+	}
+	if s.table == "" {
+		s.table = defaultOutboxTable
+	}
+	if s.placeholder == nil {
+		s.placeholder = QuestionPlaceholder
+	}
+	return s
+}
+
+// Enqueue validates an event and adds it to the outbox using the given
+// executor. Passing the *sql.Tx that stores the corresponding business data
+// guarantees that the event is pushed if and only if the transaction
+// commits.
+func (s *SQLOutboxStore) Enqueue(ctx context.Context, exec SQLExecer, topic string, e *Event) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	query := fmt.Sprintf(
+		"INSERT INTO %s (topic, payload, attempts, created_at, retry_at) VALUES (%s, %s, 0, %s, %s)",
+		s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3), s.placeholder(4))
+	_, err = exec.ExecContext(ctx, query, topic, string(payload), now, now)
+	return err
+}
+
+// Pending implements OutboxStore. Messages whose payload cannot be decoded
+// are quarantined and skipped.
+func (s *SQLOutboxStore) Pending(ctx context.Context, now time.Time, limit int) ([]*OutboxMessage, error) {
+	query := fmt.Sprintf(
+		"SELECT id, topic, payload, attempts, created_at FROM %s WHERE delivered_at IS NULL AND retry_at <= %s ORDER BY id LIMIT %d",
+		s.table, s.placeholder(1), limit)
+	rows, err := s.db.QueryContext(ctx, query, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		result    []*OutboxMessage
+		malformed []*OutboxMessage
+		errs      []error
+	)
+	for rows.Next() {
+		var (
+			msg     OutboxMessage
+			payload string
+		)
+		if err := rows.Scan(&msg.ID, &msg.Topic, &payload, &msg.Attempts, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		if msg.Event, err = unmarshalOutboxEvent([]byte(payload)); err != nil {
+			malformed, errs = append(malformed, &msg), append(errs, err)
+			continue
+		}
+		result = append(result, &msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Rows are closed first, as some drivers cannot run a statement while
+	// a result set is open.
+	rows.Close()
+	for i, msg := range malformed {
+		if err := s.MarkFailed(ctx, msg.ID, outboxQuarantine); err != nil {
+			return nil, fmt.Errorf("routemaster: quarantining outbox message %d failed: %w", msg.ID, err)
+		}
+		if s.onMalformed != nil {
+			s.onMalformed(msg.ID, fmt.Errorf("routemaster: outbox message %d: %w", msg.ID, errs[i]))
+		}
+	}
+	return result, nil
+}
+
+// MarkDelivered implements OutboxStore.
+func (s *SQLOutboxStore) MarkDelivered(ctx context.Context, id int64) error {
+	query := fmt.Sprintf("UPDATE %s SET delivered_at = %s WHERE id = %s",
+		s.table, s.placeholder(1), s.placeholder(2))
+	_, err := s.db.ExecContext(ctx, query, time.Now().UTC(), id)
+	return err
+}
+
+// MarkFailed implements OutboxStore.
+func (s *SQLOutboxStore) MarkFailed(ctx context.Context, id int64, retryAt time.Time) error {
+	query := fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, retry_at = %s WHERE id = %s",
+		s.table, s.placeholder(1), s.placeholder(2))
+	_, err := s.db.ExecContext(ctx, query, retryAt.UTC(), id)
+	return err
+}
+
+// outboxPayload is the serialised form of an Event in persistent stores. Data
+// is kept raw so that it is pushed exactly as it was stored.
+type outboxPayload struct {
+	Type      string          `json:"type"`
+	URL       string          `json:"url"`
+	Timestamp int64           `json:"timestamp,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+func unmarshalOutboxEvent(b []byte) (*Event, error) {
+	var p outboxPayload
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	e := &Event{Type: p.Type, URL: p.URL, Timestamp: p.Timestamp}
+	if len(p.Data) > 0 {
+		e.Data = p.Data
+	}
+	return e, nil
+}
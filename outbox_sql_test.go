@@ -0,0 +1,222 @@
+package routemaster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDB is a database/sql driver recording the statements it runs, and
+// answering queries with the rows it holds.
+type fakeDB struct {
+	mu         sync.Mutex
+	statements []fakeStatement
+	rows       [][]driver.Value
+}
+
+type fakeStatement struct {
+	query string
+	args  []driver.Value
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = make(map[string]*fakeDB)
+)
+
+func init() {
+	sql.Register("routemaster-fake", fakeDriver{})
+}
+
+// openFakeDB returns a *sql.DB backed by a new fakeDB.
+func openFakeDB(t *testing.T) (*sql.DB, *fakeDB) {
+	fake := &fakeDB{}
+	fakeDBsMu.Lock()
+	fakeDBs[t.Name()] = fake
+	fakeDBsMu.Unlock()
+	db, err := sql.Open("routemaster-fake", t.Name())
+	must(err)
+	t.Cleanup(func() { db.Close() })
+	return db, fake
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	return &fakeConn{db: fakeDBs[name]}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeConn) Commit() error             { return nil }
+func (c *fakeConn) Rollback() error           { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) record(args []driver.Value) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.statements = append(s.db.statements, fakeStatement{query: s.query, args: args})
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.record(args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.record(args)
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	return &fakeRows{rows: s.db.rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id", "topic", "payload", "attempts", "created_at"}
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQLOutboxStore(t *testing.T) {
+	db, fake := openFakeDB(t)
+	var malformed []int64
+	store := NewSQLOutboxStore(&SQLOutboxConfig{
+		DB:          db,
+		Table:       "outbox",
+		Placeholder: DollarPlaceholder,
+		OnMalformed: func(id int64, err error) { malformed = append(malformed, id) },
+	})
+	ctx := context.Background()
+	event := &Event{Type: "create", URL: "https://orders/1", Data: M{"id": 1}}
+
+	// Enqueue.
+	must(store.Enqueue(ctx, db, "orders", event))
+	insert := fake.statements[0]
+	if want := "INSERT INTO outbox (topic, payload, attempts, created_at, retry_at) VALUES ($1, $2, 0, $3, $4)"; insert.query != want {
+		t.Errorf("insert: got %q, want %q", insert.query, want)
+	}
+	if len(insert.args) != 4 || insert.args[0] != "orders" {
+		t.Fatalf("insert args: got %v", insert.args)
+	}
+	payload := insert.args[1].(string)
+
+	// Pending, with a malformed row in between.
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake.rows = [][]driver.Value{
+		{int64(1), "orders", payload, int64(0), created},
+		{int64(2), "orders", "{", int64(0), created},
+		{int64(3), "riders", payload, int64(2), created},
+	}
+	fake.statements = nil
+	now := time.Now()
+	msgs, err := store.Pending(ctx, now, 10)
+	must(err)
+	if len(msgs) != 2 || msgs[0].ID != 1 || msgs[1].ID != 3 || msgs[1].Topic != "riders" || msgs[1].Attempts != 2 {
+		t.Fatalf("pending: got %+v", msgs)
+	}
+	if got := msgs[0].Event; got.Type != event.Type || got.URL != event.URL ||
+		string(got.Data.(json.RawMessage)) != `{"id":1}` {
+		t.Errorf("event: got %+v", got)
+	}
+	if want := []int64{2}; !reflect.DeepEqual(malformed, want) {
+		t.Errorf("malformed: got %v, want %v", malformed, want)
+	}
+	want := []fakeStatement{
+		{
+			query: "SELECT id, topic, payload, attempts, created_at FROM outbox WHERE delivered_at IS NULL AND retry_at <= $1 ORDER BY id LIMIT 10",
+			args:  []driver.Value{now.UTC()},
+		},
+		{
+			query: "UPDATE outbox SET attempts = attempts + 1, retry_at = $1 WHERE id = $2",
+			args:  []driver.Value{outboxQuarantine, int64(2)},
+		},
+	}
+	if !reflect.DeepEqual(fake.statements, want) {
+		t.Errorf("pending statements: got %v, want %v", fake.statements, want)
+	}
+
+	// MarkDelivered and MarkFailed.
+	fake.statements = nil
+	retryAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	must(store.MarkDelivered(ctx, 1))
+	must(store.MarkFailed(ctx, 3, retryAt))
+	if got, want := fake.statements[0].query, "UPDATE outbox SET delivered_at = $1 WHERE id = $2"; got != want {
+		t.Errorf("mark delivered: got %q, want %q", got, want)
+	}
+	if got, want := fake.statements[1].args, []driver.Value{retryAt, int64(3)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mark failed args: got %v, want %v", got, want)
+	}
+}
+
+func TestSQLOutboxStoreDefaults(t *testing.T) {
+	db, fake := openFakeDB(t)
+	store := NewSQLOutboxStore(&SQLOutboxConfig{DB: db})
+	must(store.MarkDelivered(context.Background(), 1))
+	if got, want := fake.statements[0].query, "UPDATE routemaster_outbox SET delivered_at = ? WHERE id = ?"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestUnmarshalOutboxEvent(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    *Event
+		err     bool
+	}{
+		{
+			payload: `{"type":"create","url":"https://orders/1","timestamp":5,"data":{"id":1}}`,
+			want:    &Event{Type: "create", URL: "https://orders/1", Timestamp: 5, Data: json.RawMessage(`{"id":1}`)},
+		},
+		{
+			payload: `{"type":"delete","url":"https://orders/1"}`,
+			want:    &Event{Type: "delete", URL: "https://orders/1"},
+		},
+		{payload: `{"type":`, err: true},
+		{payload: `[]`, err: true},
+	}
+	for _, tt := range tests {
+		got, err := unmarshalOutboxEvent([]byte(tt.payload))
+		if (err != nil) != tt.err {
+			t.Errorf("%s: got error %v, want error: %v", tt.payload, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.payload, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package routemaster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	var (
+		pushes int32
+		fail   int32 = 1
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		atomic.AddInt32(&pushes, 1)
+	}))
+	defer ts.Close()
+	client, err := NewClient(&Config{URL: ts.URL, UUID: "demo"})
+	must(err)
+
+	store := NewMemoryOutboxStore()
+	must(store.Enqueue("orders", &Event{Type: "create", URL: "https://orders/1"}))
+	must(store.Enqueue("orders", &Event{Type: "update", URL: "https://orders/1"}))
+	if err := store.Enqueue("orders", &Event{Type: "bad"}); err == nil {
+		t.Error("expected validation error")
+	}
+
+	var failures int32
+	relay := NewRelay(client, &RelayConfig{
+		Store:          store,
+		InitialBackoff: time.Hour,
+		MaxBackoff:     time.Hour,
+		OnError: func(msg *OutboxMessage, err error) {
+			atomic.AddInt32(&failures, 1)
+		},
+	})
+
+	// Failed pushes are rescheduled and not retried before their backoff.
+	n, err := relay.Drain(context.Background())
+	must(err)
+	if n != 2 || atomic.LoadInt32(&failures) != 2 {
+		t.Errorf("first drain: got %d messages and %d failures, want 2 and 2", n, failures)
+	}
+	atomic.StoreInt32(&fail, 0)
+	n, err = relay.Drain(context.Background())
+	must(err)
+	if n != 0 {
+		t.Errorf("second drain: got %d messages, want 0", n)
+	}
+	if got := store.Undelivered(); got != 2 {
+		t.Errorf("undelivered: got %d, want %d", got, 2)
+	}
+
+	// Once due, messages are pushed and marked as delivered.
+	msgs, err := store.Pending(context.Background(), time.Now().Add(2*time.Hour), 10)
+	must(err)
+	for _, msg := range msgs {
+		if msg.Attempts != 1 {
+			t.Errorf("attempts: got %d, want %d", msg.Attempts, 1)
+		}
+		must(store.MarkFailed(context.Background(), msg.ID, time.Now()))
+	}
+	n, err = relay.Drain(context.Background())
+	must(err)
+	if n != 2 || atomic.LoadInt32(&pushes) != 2 {
+		t.Errorf("third drain: got %d messages and %d pushes, want 2 and 2", n, pushes)
+	}
+	if got := store.Undelivered(); got != 0 {
+		t.Errorf("undelivered: got %d, want %d", got, 0)
+	}
+}
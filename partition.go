@@ -0,0 +1,105 @@
+package routemaster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const defaultPartitionConcurrency = 4
+
+// PartitionConfig specifies how a Listener splits batches into partitions
+// handled concurrently. Events of a partition are passed to a single handler
+// invocation, in the order they were received; the batch is acknowledged
+// only once all of its partitions have been handled successfully.
+type PartitionConfig struct {
+	// Key returns the partition of an event. Defaults to PartitionByTopic.
+	Key func(*ReceivedEvent) string
+
+	// Concurrency is the number of partitions handled at once. Defaults to
+	// 4.
+	Concurrency int
+}
+
+// PartitionByTopic partitions events by topic.
+func PartitionByTopic(e *ReceivedEvent) string {
+	return e.Topic
+}
+
+// PartitionByEntity partitions events by entity, i.e. by topic and URL.
+func PartitionByEntity(e *ReceivedEvent) string {
+	return e.Topic + " " + e.URL
+}
+
+// partitioned returns a handler invoking h concurrently with each partition
+// of a batch.
+func partitioned(h ResultHandlerFunc, cfg *PartitionConfig) ResultHandlerFunc {
+	key := cfg.Key
+	if key == nil {
+		key = PartitionByTopic
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultPartitionConcurrency
+	}
+	return func(ctx context.Context, events []*ReceivedEvent) []EventResult {
+		partitions := partition(events, key)
+		if len(partitions) == 1 {
+			return h(ctx, events)
+		}
+
+		var (
+			results  = make([]EventResult, len(events))
+			sem      = make(chan struct{}, concurrency)
+			inFlight sync.WaitGroup
+		)
+		for _, indexes := range partitions {
+			sem <- struct{}{}
+			inFlight.Add(1)
+			go func(indexes []int) {
+				p := make([]*ReceivedEvent, len(indexes))
+				for i, index := range indexes {
+					p[i] = events[index]
+				}
+				var partResults []EventResult
+				defer func() {
+					// A panic cannot be recovered by the Listener from
+					// another goroutine.
+					if r := recover(); r != nil {
+						partResults = fill(len(p), EventResult{Outcome: OutcomeRetry, Err: fmt.Errorf("panic: %+v", r)})
+					}
+					partResults = checkResults(p, partResults)
+					for i, index := range indexes {
+						results[index] = partResults[i]
+					}
+					<-sem
+					inFlight.Done()
+				}()
+				partResults = h(ctx, p)
+			}(indexes)
+		}
+		inFlight.Wait()
+		return results
+	}
+}
+
+// partition splits events by key, returning the indexes of the events of
+// each partition. The order of events within a partition is kept, and
+// partitions are ordered by their first event.
+func partition(events []*ReceivedEvent, key func(*ReceivedEvent) string) [][]int {
+	var (
+		partitions [][]int
+		byKey      = make(map[string]int)
+	)
+	for i, e := range events {
+		k := key(e)
+		p, ok := byKey[k]
+		if !ok {
+			p = len(partitions)
+			byKey[k] = p
+			partitions = append(partitions, nil)
+		}
+		partitions[p] = append(partitions[p], i)
+	}
+	return partitions
+}
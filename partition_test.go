@@ -0,0 +1,164 @@
+package routemaster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPartition(t *testing.T) {
+	events := []*ReceivedEvent{
+		{Topic: "orders", URL: "https://orders/1"},
+		{Topic: "riders", URL: "https://riders/1"},
+		{Topic: "orders", URL: "https://orders/2"},
+		{Topic: "orders", URL: "https://orders/1"},
+	}
+	urls := func(partitions [][]int) [][]string {
+		var result [][]string
+		for _, p := range partitions {
+			var urls []string
+			for _, i := range p {
+				urls = append(urls, events[i].URL)
+			}
+			result = append(result, urls)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name string
+		key  func(*ReceivedEvent) string
+		want [][]string
+	}{
+		{
+			name: "by topic",
+			key:  PartitionByTopic,
+			want: [][]string{
+				{"https://orders/1", "https://orders/2", "https://orders/1"},
+				{"https://riders/1"},
+			},
+		},
+		{
+			name: "by entity",
+			key:  PartitionByEntity,
+			want: [][]string{
+				{"https://orders/1", "https://orders/1"},
+				{"https://riders/1"},
+				{"https://orders/2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := urls(partition(events, tt.key)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListenerPartition(t *testing.T) {
+	post := func(l *Listener, topics ...string) int {
+		var events []string
+		for i, topic := range topics {
+			events = append(events, fmt.Sprintf(
+				`{"topic":%q,"type":"update","url":"https://%s/%d","t":%d}`, topic, topic, i, i))
+		}
+		req := httptest.NewRequest(http.MethodPost, "/",
+			bytes.NewBufferString("["+strings.Join(events, ",")+"]"))
+		req.SetBasicAuth("secret", "")
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("concurrency", func(t *testing.T) {
+		// The orders partition waits for the riders partition, which would
+		// deadlock if partitions were handled sequentially.
+		ridersDone := make(chan struct{})
+		listener := NewListener(&ListenerConfig{
+			ContextHandler: func(ctx context.Context, events []*ReceivedEvent) error {
+				switch events[0].Topic {
+				case "orders":
+					select {
+					case <-ridersDone:
+					case <-time.After(5 * time.Second):
+						return errors.New("timed out")
+					}
+				case "riders":
+					close(ridersDone)
+				}
+				return nil
+			},
+			UUID:      "secret",
+			Partition: &PartitionConfig{},
+		})
+		if code := post(listener, "orders", "riders"); code != http.StatusOK {
+			t.Errorf("status: got %d, want %d", code, http.StatusOK)
+		}
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		var (
+			mu                sync.Mutex
+			inFlight, maxSeen int
+		)
+		listener := NewListener(&ListenerConfig{
+			Handler: func(events []*ReceivedEvent) error {
+				mu.Lock()
+				inFlight++
+				if inFlight > maxSeen {
+					maxSeen = inFlight
+				}
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				return nil
+			},
+			UUID:      "secret",
+			Partition: &PartitionConfig{Concurrency: 2},
+		})
+		post(listener, "a", "b", "c", "d", "e")
+		if maxSeen != 2 {
+			t.Errorf("max concurrency: got %d, want %d", maxSeen, 2)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		var (
+			mu      sync.Mutex
+			handled []string
+		)
+		listener := NewListener(&ListenerConfig{
+			Handler: func(events []*ReceivedEvent) error {
+				switch events[0].Topic {
+				case "orders":
+					return errors.New("failed")
+				case "riders":
+					panic("boom")
+				}
+				mu.Lock()
+				handled = append(handled, events[0].Topic)
+				mu.Unlock()
+				return nil
+			},
+			OnError:   func(error) {},
+			UUID:      "secret",
+			Partition: &PartitionConfig{},
+		})
+		if code := post(listener, "orders", "riders", "drivers"); code != http.StatusInternalServerError {
+			t.Errorf("status: got %d, want %d", code, http.StatusInternalServerError)
+		}
+		if !reflect.DeepEqual(handled, []string{"drivers"}) {
+			t.Errorf("handled: got %v, want %v", handled, []string{"drivers"})
+		}
+	})
+}
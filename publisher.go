@@ -0,0 +1,243 @@
+package routemaster
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Defaults applied to the zero fields of a PublisherConfig.
+const (
+	defaultQueueSize = 1000
+	defaultWorkers   = 4
+)
+
+var (
+	// ErrQueueFull is returned, or reported, when an event is published
+	// while the Publisher's queue is full.
+	ErrQueueFull = errors.New("routemaster: publisher queue full")
+
+	// ErrPublisherClosed is returned when an event is published after the
+	// Publisher has been closed.
+	ErrPublisherClosed = errors.New("routemaster: publisher closed")
+)
+
+// OverflowPolicy specifies how a Publisher behaves when its queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks Publish until there is room in the queue, or its
+	// context is done.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDrop discards the event and reports ErrQueueFull to the
+	// Publisher's OnError callback. Publish returns nil.
+	OverflowDrop
+
+	// OverflowError discards the event and returns ErrQueueFull from
+	// Publish.
+	OverflowError
+)
+
+// PublisherConfig specifies the way a Publisher should be set up.
+type PublisherConfig struct {
+	// QueueSize is the maximum number of events waiting to be pushed.
+	// Defaults to 1000.
+	QueueSize int
+
+	// Workers is the number of events pushed concurrently. Defaults to 4.
+	Workers int
+
+	// Overflow specifies what happens when the queue is full. Defaults to
+	// OverflowBlock.
+	Overflow OverflowPolicy
+
+	// OnError is called with every event that could not be pushed. Optional.
+	// It is called from the Publisher's workers, and must be safe for
+	// concurrent use.
+	OnError func(topic string, e *Event, err error)
+}
+
+// A Publisher pushes events to the bus asynchronously. Events are buffered in
+// a bounded in-memory queue and pushed by a pool of workers, so they are lost
+// if the process exits before they are flushed.
+type Publisher struct {
+	client   *Client
+	overflow OverflowPolicy
+	onError  func(string, *Event, error)
+
+	queue   chan *publication
+	workers sync.WaitGroup
+
+	// ctx is cancelled to abort in-flight pushes when Close gives up.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu guards closed and the sends to queue, so that the queue is never
+	// written to after it has been closed.
+	mu     sync.RWMutex
+	closed bool
+
+	// closing is closed as soon as Close is called, so that the calls to
+	// Publish waiting for room in the queue release mu.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// pendingMu guards pending and idle. idle is closed whenever pending
+	// drops to zero.
+	pendingMu sync.Mutex
+	pending   int
+	idle      chan struct{}
+}
+
+type publication struct {
+	topic string
+	event *Event
+}
+
+// NewPublisher creates a Publisher pushing events through the given client,
+// and starts its workers.
+func NewPublisher(client *Client, cfg *PublisherConfig) *Publisher {
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	idle := make(chan struct{})
+	close(idle)
+	p := &Publisher{
+		client:   client,
+		overflow: cfg.Overflow,
+		onError:  cfg.OnError,
+		queue:    make(chan *publication, queueSize),
+		ctx:      ctx,
+		cancel:   cancel,
+		closing:  make(chan struct{}),
+		idle:     idle,
+	}
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Publish validates an event and queues it to be pushed to the given topic.
+// ctx only bounds the time spent waiting for room in the queue; the push
+// itself happens in the background.
+func (p *Publisher) Publish(ctx context.Context, topic string, e *Event) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPublisherClosed
+	}
+
+	pub := &publication{topic: topic, event: e}
+	p.addPending(1)
+	select {
+	case p.queue <- pub:
+		return nil
+	default:
+	}
+
+	switch p.overflow {
+	case OverflowDrop:
+		p.addPending(-1)
+		p.reportError(pub, ErrQueueFull)
+		return nil
+	case OverflowError:
+		p.addPending(-1)
+		return ErrQueueFull
+	}
+	select {
+	case p.queue <- pub:
+		return nil
+	case <-ctx.Done():
+		p.addPending(-1)
+		return ctx.Err()
+	case <-p.closing:
+		p.addPending(-1)
+		return ErrPublisherClosed
+	}
+}
+
+// Flush waits until every event queued so far has been pushed, or ctx is
+// done.
+func (p *Publisher) Flush(ctx context.Context) error {
+	p.pendingMu.Lock()
+	idle := p.idle
+	p.pendingMu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting new events, making calls to Publish waiting for room
+// in the queue return ErrPublisherClosed, and waits for the queued ones to be
+// pushed. If ctx is done first, in-flight pushes are cancelled, the remaining
+// events are reported to OnError, and the context's error is returned.
+func (p *Publisher) Close(ctx context.Context) error {
+	p.closeOnce.Do(func() { close(p.closing) })
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (p *Publisher) work() {
+	defer p.workers.Done()
+	for pub := range p.queue {
+		if err := p.ctx.Err(); err != nil {
+			p.reportError(pub, err)
+		} else if err := p.client.PushContext(p.ctx, pub.topic, pub.event); err != nil {
+			p.reportError(pub, err)
+		}
+		p.addPending(-1)
+	}
+}
+
+func (p *Publisher) reportError(pub *publication, err error) {
+	if p.onError != nil {
+		p.onError(pub.topic, pub.event, err)
+	}
+}
+
+func (p *Publisher) addPending(delta int) {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	if p.pending == 0 && delta > 0 {
+		p.idle = make(chan struct{})
+	}
+	p.pending += delta
+	if p.pending == 0 {
+		close(p.idle)
+	}
+}
@@ -0,0 +1,172 @@
+package routemaster
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPublisher(t *testing.T) {
+	event := &Event{Type: "create", URL: "https://orders/1"}
+
+	t.Run("flush and close", func(t *testing.T) {
+		var pushes int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&pushes, 1)
+		}))
+		defer ts.Close()
+		client, err := NewClient(&Config{URL: ts.URL, UUID: "demo"})
+		must(err)
+
+		p := NewPublisher(client, &PublisherConfig{Workers: 2})
+		for i := 0; i < 10; i++ {
+			must(p.Publish(context.Background(), "orders", event))
+		}
+		must(p.Flush(context.Background()))
+		if got := atomic.LoadInt32(&pushes); got != 10 {
+			t.Errorf("pushes: got %d, want %d", got, 10)
+		}
+
+		must(p.Close(context.Background()))
+		if err := p.Publish(context.Background(), "orders", event); err != ErrPublisherClosed {
+			t.Errorf("publish after close: got %v, want %v", err, ErrPublisherClosed)
+		}
+	})
+
+	t.Run("invalid event", func(t *testing.T) {
+		client, err := NewClient(&Config{URL: "https://routemaster.dev", UUID: "demo"})
+		must(err)
+		p := NewPublisher(client, &PublisherConfig{})
+		defer p.Close(context.Background())
+
+		if err := p.Publish(context.Background(), "orders", &Event{Type: "bad"}); err == nil {
+			t.Error("expected validation error")
+		}
+	})
+
+	t.Run("push failure", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer ts.Close()
+		client, err := NewClient(&Config{URL: ts.URL, UUID: "demo"})
+		must(err)
+
+		var mu sync.Mutex
+		var failed []string
+		p := NewPublisher(client, &PublisherConfig{
+			OnError: func(topic string, e *Event, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				failed = append(failed, topic)
+			},
+		})
+		must(p.Publish(context.Background(), "orders", event))
+		must(p.Close(context.Background()))
+
+		if len(failed) != 1 || failed[0] != "orders" {
+			t.Errorf("failed topics: got %v, want [orders]", failed)
+		}
+	})
+
+	t.Run("queue full", func(t *testing.T) {
+		unblock := make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-unblock
+		}))
+		defer ts.Close()
+		client, err := NewClient(&Config{URL: ts.URL, UUID: "demo"})
+		must(err)
+
+		var dropped int32
+		tests := []struct {
+			overflow OverflowPolicy
+			wantErr  error
+		}{
+			{OverflowError, ErrQueueFull},
+			{OverflowDrop, nil},
+			{OverflowBlock, context.DeadlineExceeded},
+		}
+		for _, tt := range tests {
+			p := NewPublisher(client, &PublisherConfig{
+				QueueSize: 1,
+				Workers:   1,
+				Overflow:  tt.overflow,
+				OnError: func(topic string, e *Event, err error) {
+					if errors.Is(err, ErrQueueFull) {
+						atomic.AddInt32(&dropped, 1)
+					}
+				},
+			})
+			// The first event is picked up by the worker, which blocks; the
+			// second fills the queue.
+			must(p.Publish(context.Background(), "orders", event))
+			for len(p.queue) != 0 {
+				time.Sleep(time.Millisecond)
+			}
+			must(p.Publish(context.Background(), "orders", event))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			if err := p.Publish(ctx, "orders", event); err != tt.wantErr {
+				t.Errorf("overflow %d: got %v, want %v", tt.overflow, err, tt.wantErr)
+			}
+			cancel()
+
+			ctx, cancel = context.WithCancel(context.Background())
+			cancel()
+			_ = p.Close(ctx)
+		}
+		close(unblock)
+
+		if got := atomic.LoadInt32(&dropped); got != 1 {
+			t.Errorf("dropped: got %d, want %d", got, 1)
+		}
+	})
+
+	t.Run("close while blocked", func(t *testing.T) {
+		unblock := make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-unblock:
+			case <-r.Context().Done():
+			}
+		}))
+		defer ts.Close()
+		defer close(unblock)
+		client, err := NewClient(&Config{URL: ts.URL, UUID: "demo"})
+		must(err)
+
+		p := NewPublisher(client, &PublisherConfig{QueueSize: 1, Workers: 1})
+		must(p.Publish(context.Background(), "orders", event))
+		for len(p.queue) != 0 {
+			time.Sleep(time.Millisecond)
+		}
+		must(p.Publish(context.Background(), "orders", event))
+
+		// Publish blocks until there is room in the queue.
+		published := make(chan error, 1)
+		go func() { published <- p.Publish(context.Background(), "orders", event) }()
+		time.Sleep(10 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		closed := make(chan error, 1)
+		go func() { closed <- p.Close(ctx) }()
+		select {
+		case err := <-closed:
+			if err != context.DeadlineExceeded {
+				t.Errorf("close: got %v, want %v", err, context.DeadlineExceeded)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("close did not return")
+		}
+		if err := <-published; err != ErrPublisherClosed {
+			t.Errorf("blocked publish: got %v, want %v", err, ErrPublisherClosed)
+		}
+	})
+}
@@ -0,0 +1,294 @@
+package routemaster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QueuedBatch is a batch of events stored in a Queue, waiting to be handled.
+type QueuedBatch struct {
+	// ID uniquely identifies the batch within its queue.
+	ID int64
+
+	// Events are the events of the batch, as delivered by the bus.
+	Events []*ReceivedEvent
+
+	// Attempts is the number of failed attempts at handling the batch.
+	Attempts int
+
+	// ReceivedAt is when the batch was added to the queue.
+	ReceivedAt time.Time
+}
+
+// A Queue persists the batches received by an asynchronous Listener until
+// they have been handled. See AsyncConfig.
+type Queue interface {
+	// Enqueue durably stores a batch. The Listener acknowledges the batch
+	// to the bus once Enqueue has returned.
+	Enqueue(ctx context.Context, events []*ReceivedEvent) error
+
+	// Pending returns up to limit batches that are due for an attempt at the
+	// given time, oldest first. Batches that cannot be read should be set
+	// aside and reported with a *QuarantineError, returned along with the
+	// other batches.
+	Pending(ctx context.Context, now time.Time, limit int) ([]*QueuedBatch, error)
+
+	// Ack removes the batch with the given ID, once it has been handled.
+	Ack(ctx context.Context, id int64) error
+
+	// Retry records a failed attempt at handling the batch with the given
+	// ID, and defers the next attempt until retryAt.
+	Retry(ctx context.Context, id int64, retryAt time.Time) error
+}
+
+// QuarantineError is returned by Queue.Pending along with the batches it
+// could read, when others could not be read and have been set aside so that
+// they do not block the queue. A Listener reports it to OnError and handles
+// the other batches.
+type QuarantineError struct {
+	// Errs describe the batches set aside.
+	Errs []error
+}
+
+func (e *QuarantineError) Error() string {
+	return errors.Join(e.Errs...).Error()
+}
+
+// Unwrap returns Errs.
+func (e *QuarantineError) Unwrap() []error {
+	return e.Errs
+}
+
+// MemoryQueue is a Queue that keeps batches in memory. It is intended for
+// tests; see FileQueue for a durable implementation.
+type MemoryQueue struct {
+	mu      sync.Mutex
+	nextID  int64
+	batches map[int64]*memoryQueueEntry
+}
+
+type memoryQueueEntry struct {
+	batch   QueuedBatch
+	retryAt time.Time
+}
+
+// NewMemoryQueue creates an empty MemoryQueue.
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{batches: make(map[int64]*memoryQueueEntry)}
+}
+
+// Len returns the number of batches not yet handled.
+func (q *MemoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.batches)
+}
+
+// Enqueue implements Queue.
+func (q *MemoryQueue) Enqueue(ctx context.Context, events []*ReceivedEvent) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	q.batches[q.nextID] = &memoryQueueEntry{
+		batch: QueuedBatch{
+			ID:         q.nextID,
+			Events:     events,
+			ReceivedAt: time.Now(),
+		},
+	}
+	return nil
+}
+
+// Pending implements Queue.
+func (q *MemoryQueue) Pending(ctx context.Context, now time.Time, limit int) ([]*QueuedBatch, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := make([]int64, 0, len(q.batches))
+	for id, b := range q.batches {
+		if !b.retryAt.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	result := make([]*QueuedBatch, len(ids))
+	for i, id := range ids {
+		batch := q.batches[id].batch
+		result[i] = &batch
+	}
+	return result, nil
+}
+
+// Ack implements Queue.
+func (q *MemoryQueue) Ack(ctx context.Context, id int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.batches, id)
+	return nil
+}
+
+// Retry implements Queue.
+func (q *MemoryQueue) Retry(ctx context.Context, id int64, retryAt time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if b, ok := q.batches[id]; ok {
+		b.batch.Attempts++
+		b.retryAt = retryAt
+	}
+	return nil
+}
+
+// Defaults applied to the zero fields of an AsyncConfig.
+const (
+	defaultAsyncBatchSize      = 100
+	defaultAsyncPollInterval   = time.Second
+	defaultAsyncInitialBackoff = time.Second
+	defaultAsyncMaxBackoff     = 5 * time.Minute
+)
+
+// AsyncConfig specifies the way an asynchronous Listener handles the batches
+// it has queued.
+//
+// An asynchronous Listener acknowledges a delivery with 204 No Content as
+// soon as the batch is stored in Queue, and handles queued batches when Run
+// is called, so that slow handlers do not cause the bus to time out and
+// redeliver. Deduplication and squashing are applied when a batch is
+// handled.
+type AsyncConfig struct {
+	// Queue stores the batches received.
+	Queue Queue
+
+	// BatchSize is the maximum number of batches read from the queue at
+	// once. Defaults to 100.
+	BatchSize int
+
+	// PollInterval is how long Run waits before checking the queue again
+	// once it is drained. Defaults to 1s.
+	PollInterval time.Duration
+
+	// InitialBackoff is how long a batch is held back after its first
+	// failed attempt. It doubles after every subsequent failure. Defaults to
+	// 1s.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the time a batch is held back after a failed attempt.
+	// Defaults to 5m.
+	MaxBackoff time.Duration
+}
+
+// errNotAsync is returned by Run and Drain on a synchronous Listener.
+var errNotAsync = errors.New("routemaster: listener has no queue")
+
+// newAsync applies the defaults of an AsyncConfig.
+func newAsync(cfg *AsyncConfig) *AsyncConfig {
+	async := *cfg
+	if async.BatchSize <= 0 {
+		async.BatchSize = defaultAsyncBatchSize
+	}
+	if async.PollInterval <= 0 {
+		async.PollInterval = defaultAsyncPollInterval
+	}
+	if async.InitialBackoff <= 0 {
+		async.InitialBackoff = defaultAsyncInitialBackoff
+	}
+	if async.MaxBackoff <= 0 {
+		async.MaxBackoff = defaultAsyncMaxBackoff
+	}
+	return &async
+}
+
+// Run handles the batches queued by an asynchronous Listener until ctx is
+// done, then returns the context's error. Handler and queue errors are
+// reported to OnError.
+func (l *Listener) Run(ctx context.Context) error {
+	if l.async == nil {
+		return errNotAsync
+	}
+	for {
+		n, err := l.Drain(ctx)
+		if err != nil && ctx.Err() == nil {
+			l.reportAsyncError(ctx, err)
+		}
+		// Poll again straight away if the last batch was full.
+		if err == nil && n == l.async.BatchSize {
+			continue
+		}
+		if err := sleep(ctx, l.async.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// Drain makes one attempt at handling each batch currently due in the queue
+// of an asynchronous Listener, reading at most BatchSize batches. It returns
+// the number of batches read. Handler failures are reported to OnError and
+// rescheduled, as are batches the queue set aside; only queue errors and
+// context errors are returned.
+func (l *Listener) Drain(ctx context.Context) (int, error) {
+	if l.async == nil {
+		return 0, errNotAsync
+	}
+	batches, err := l.async.Queue.Pending(ctx, time.Now(), l.async.BatchSize)
+	var quarantined *QuarantineError
+	if errors.As(err, &quarantined) {
+		l.reportAsyncError(ctx, err)
+	} else if err != nil {
+		return 0, err
+	}
+	for _, b := range batches {
+		if err := ctx.Err(); err != nil {
+			return len(batches), err
+		}
+		if err := l.handleQueued(ctx, b); err != nil {
+			if ctx.Err() != nil {
+				return len(batches), ctx.Err()
+			}
+			wait := exponentialBackoff(l.async.InitialBackoff, l.async.MaxBackoff, b.Attempts+1)
+			l.logger.LogAttrs(ctx, slog.LevelError, "routemaster queued batch failed",
+				slog.Int64("id", b.ID),
+				slog.Int("events", len(b.Events)),
+				slog.Int("attempt", b.Attempts+1),
+				slog.Duration("retry_in", wait),
+				slog.Any("error", err))
+			l.reportAsyncError(ctx, err)
+			if err := l.async.Queue.Retry(ctx, b.ID, time.Now().Add(wait)); err != nil {
+				return len(batches), err
+			}
+			continue
+		}
+		if err := l.async.Queue.Ack(ctx, b.ID); err != nil {
+			return len(batches), err
+		}
+	}
+	return len(batches), nil
+}
+
+// handleQueued handles a queued batch, turning panics into errors.
+func (l *Listener) handleQueued(ctx context.Context, b *QueuedBatch) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %+v", r)
+		}
+	}()
+	return l.handle(ctx, b.Events)
+}
+
+// reportAsyncError reports an error of an asynchronous Listener to OnError.
+func (l *Listener) reportAsyncError(ctx context.Context, err error) {
+	if l.onError == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			l.logger.ErrorContext(ctx, "panic while running error handler", slog.Any("panic", r))
+		}
+	}()
+	l.onError(err)
+}
@@ -0,0 +1,267 @@
+package routemaster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileQueueExt = ".json"
+
+	// fileQueueCorruptExt is appended to the name of the files that cannot
+	// be read, which are then ignored.
+	fileQueueCorruptExt = ".corrupt"
+)
+
+// FileQueue is a durable Queue storing each batch in its own file within a
+// directory. Files are written atomically and synced before Enqueue returns,
+// so that acknowledged batches survive crashes.
+//
+// Files that cannot be read are renamed with a .corrupt extension, and
+// reported with a *QuarantineError by the next call to Pending. Once
+// repaired, they are handled again if renamed back and the queue reopened.
+//
+// A directory must be used by a single FileQueue at a time.
+type FileQueue struct {
+	dir string
+
+	mu     sync.Mutex
+	nextID int64
+	// retryAt indexes the batches in dir by ID.
+	retryAt map[int64]time.Time
+	// quarantined are the errors of the files set aside since the last call
+	// to Pending.
+	quarantined []error
+}
+
+// fileQueueRecord is the content of a batch file.
+type fileQueueRecord struct {
+	ID         int64            `json:"id"`
+	Events     []*ReceivedEvent `json:"events"`
+	Attempts   int              `json:"attempts"`
+	ReceivedAt time.Time        `json:"received_at"`
+	RetryAt    time.Time        `json:"retry_at"`
+}
+
+// NewFileQueue opens the queue stored in dir, creating the directory if
+// needed. Batches left by a previous FileQueue are handled again.
+func NewFileQueue(dir string) (*FileQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	q := &FileQueue{
+		dir:     dir,
+		retryAt: make(map[int64]time.Time),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			// Leftover of an interrupted write.
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		// Quarantined files keep their ID, so that it is not reused by a
+		// new batch if they are repaired and renamed back.
+		corrupt := strings.HasSuffix(name, fileQueueExt+fileQueueCorruptExt)
+		base := strings.TrimSuffix(name, fileQueueCorruptExt)
+		id, err := strconv.ParseInt(strings.TrimSuffix(base, fileQueueExt), 10, 64)
+		if err != nil || !strings.HasSuffix(base, fileQueueExt) {
+			continue
+		}
+		if id > q.nextID {
+			q.nextID = id
+		}
+		if corrupt {
+			continue
+		}
+		record, err := q.read(id)
+		if err != nil {
+			if err := q.quarantine(id, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		q.retryAt[id] = record.RetryAt
+	}
+	return q, nil
+}
+
+// Len returns the number of batches not yet handled.
+func (q *FileQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.retryAt)
+}
+
+// Enqueue implements Queue.
+func (q *FileQueue) Enqueue(ctx context.Context, events []*ReceivedEvent) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	record := &fileQueueRecord{
+		ID:         q.nextID,
+		Events:     events,
+		ReceivedAt: time.Now(),
+	}
+	if err := q.write(record); err != nil {
+		return err
+	}
+	q.retryAt[record.ID] = record.RetryAt
+	return nil
+}
+
+// Pending implements Queue.
+func (q *FileQueue) Pending(ctx context.Context, now time.Time, limit int) ([]*QueuedBatch, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := make([]int64, 0, len(q.retryAt))
+	for id, retryAt := range q.retryAt {
+		if !retryAt.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	result := make([]*QueuedBatch, 0, len(ids))
+	for _, id := range ids {
+		record, err := q.read(id)
+		if err != nil {
+			if err := q.quarantine(id, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		result = append(result, &QueuedBatch{
+			ID:         record.ID,
+			Events:     record.Events,
+			Attempts:   record.Attempts,
+			ReceivedAt: record.ReceivedAt,
+		})
+	}
+	if len(q.quarantined) > 0 {
+		err := &QuarantineError{Errs: q.quarantined}
+		q.quarantined = nil
+		return result, err
+	}
+	return result, nil
+}
+
+// Ack implements Queue.
+func (q *FileQueue) Ack(ctx context.Context, id int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := os.Remove(q.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(q.retryAt, id)
+	return nil
+}
+
+// Retry implements Queue.
+func (q *FileQueue) Retry(ctx context.Context, id int64, retryAt time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.retryAt[id]; !ok {
+		return nil
+	}
+	record, err := q.read(id)
+	if err != nil {
+		return err
+	}
+	record.Attempts++
+	record.RetryAt = retryAt
+	if err := q.write(record); err != nil {
+		return err
+	}
+	q.retryAt[id] = retryAt
+	return nil
+}
+
+func (q *FileQueue) path(id int64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, fileQueueExt))
+}
+
+// quarantine sets aside the file of the batch with the given ID, which could
+// not be read because of cause. The failure to read it is reported by the
+// next call to Pending.
+func (q *FileQueue) quarantine(id int64, cause error) error {
+	path := q.path(id)
+	if err := os.Rename(path, path+fileQueueCorruptExt); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("routemaster: quarantining queue file %s failed: %w (after: %w)", path, err, cause)
+	}
+	delete(q.retryAt, id)
+	q.quarantined = append(q.quarantined,
+		fmt.Errorf("%w; moved to %s", cause, filepath.Base(path)+fileQueueCorruptExt))
+	return syncDir(q.dir)
+}
+
+func (q *FileQueue) read(id int64) (*fileQueueRecord, error) {
+	b, err := os.ReadFile(q.path(id))
+	if err != nil {
+		return nil, err
+	}
+	var record fileQueueRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return nil, fmt.Errorf("routemaster: corrupt queue file %s: %w", q.path(id), err)
+	}
+	for _, e := range record.Events {
+		// Absent data is encoded as null.
+		if string(e.Data) == "null" {
+			e.Data = nil
+		}
+	}
+	return &record, nil
+}
+
+// write atomically replaces the file of a record: it is written to a
+// temporary file which is synced, then renamed.
+func (q *FileQueue) write(record *fileQueueRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(q.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), q.path(record.ID)); err != nil {
+		return err
+	}
+	return syncDir(q.dir)
+}
+
+// syncDir makes the renaming of files within dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,243 @@
+package routemaster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueues(t *testing.T) {
+	queues := map[string]func(t *testing.T) Queue{
+		"memory": func(t *testing.T) Queue {
+			return NewMemoryQueue()
+		},
+		"file": func(t *testing.T) Queue {
+			q, err := NewFileQueue(t.TempDir())
+			must(err)
+			return q
+		},
+	}
+	for name, newQueue := range queues {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			q := newQueue(t)
+			for _, url := range []string{"https://orders/1", "https://orders/2", "https://orders/3"} {
+				must(q.Enqueue(ctx, []*ReceivedEvent{{Topic: "orders", Type: "create", URL: url, Timestamp: 1}}))
+			}
+			urls := func(batches []*QueuedBatch) []string {
+				var urls []string
+				for _, b := range batches {
+					urls = append(urls, b.Events[0].URL)
+				}
+				return urls
+			}
+
+			now := time.Now()
+			batches, err := q.Pending(ctx, now, 2)
+			must(err)
+			if got, want := urls(batches), []string{"https://orders/1", "https://orders/2"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("pending: got %v, want %v", got, want)
+			}
+			if e := batches[0].Events[0]; e.Topic != "orders" || e.Type != "create" || e.Timestamp != 1 || e.Data != nil {
+				t.Errorf("event: got %+v", e)
+			}
+
+			must(q.Ack(ctx, batches[0].ID))
+			must(q.Retry(ctx, batches[1].ID, now.Add(time.Minute)))
+			batches, err = q.Pending(ctx, now, 10)
+			must(err)
+			if got, want := urls(batches), []string{"https://orders/3"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("pending after retry: got %v, want %v", got, want)
+			}
+
+			batches, err = q.Pending(ctx, now.Add(time.Minute), 10)
+			must(err)
+			if got, want := urls(batches), []string{"https://orders/2", "https://orders/3"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("pending once due: got %v, want %v", got, want)
+			}
+			if got := batches[0].Attempts; got != 1 {
+				t.Errorf("attempts: got %d, want %d", got, 1)
+			}
+		})
+	}
+}
+
+func TestFileQueueReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	q, err := NewFileQueue(dir)
+	must(err)
+	must(q.Enqueue(ctx, []*ReceivedEvent{{Topic: "orders", Type: "create", URL: "https://orders/1"}}))
+	must(q.Enqueue(ctx, []*ReceivedEvent{{Topic: "orders", Type: "create", URL: "https://orders/2"}}))
+	batches, err := q.Pending(ctx, time.Now(), 1)
+	must(err)
+	must(q.Ack(ctx, batches[0].ID))
+	must(os.WriteFile(filepath.Join(dir, ".tmp-123"), []byte("{"), 0o644))
+
+	q, err = NewFileQueue(dir)
+	must(err)
+	if got := q.Len(); got != 1 {
+		t.Errorf("len: got %d, want %d", got, 1)
+	}
+	must(q.Enqueue(ctx, []*ReceivedEvent{{Topic: "orders", Type: "create", URL: "https://orders/3"}}))
+	batches, err = q.Pending(ctx, time.Now(), 10)
+	must(err)
+	if len(batches) != 2 || batches[0].Events[0].URL != "https://orders/2" || batches[1].ID <= batches[0].ID {
+		t.Errorf("pending: got %+v", batches)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".tmp-123")); !os.IsNotExist(err) {
+		t.Error("expected temporary file to be removed")
+	}
+}
+
+func TestFileQueueCorrupt(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	q, err := NewFileQueue(dir)
+	must(err)
+	for _, url := range []string{"https://orders/1", "https://orders/2", "https://orders/3"} {
+		must(q.Enqueue(ctx, []*ReceivedEvent{{Topic: "orders", Type: "create", URL: url}}))
+	}
+	must(os.WriteFile(q.path(2), []byte(`{"id":2,"events":[{"top`), 0o644))
+
+	// The corrupt batch is set aside, and the others are handled.
+	var (
+		handled []string
+		errs    []error
+	)
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error {
+			handled = append(handled, events[0].URL)
+			return nil
+		},
+		OnError: func(err error) { errs = append(errs, err) },
+		UUID:    "secret",
+		Async:   &AsyncConfig{Queue: q},
+	})
+	n, err := listener.Drain(ctx)
+	must(err)
+	if n != 2 {
+		t.Errorf("drained: got %d, want %d", n, 2)
+	}
+	if want := []string{"https://orders/1", "https://orders/3"}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled: got %v, want %v", handled, want)
+	}
+	var quarantined *QuarantineError
+	if len(errs) != 1 || !errors.As(errs[0], &quarantined) || len(quarantined.Errs) != 1 {
+		t.Errorf("errors: got %v", errs)
+	}
+	if _, err := os.Stat(q.path(2) + ".corrupt"); err != nil {
+		t.Errorf("quarantined file: %v", err)
+	}
+	if _, err := q.Pending(ctx, time.Now(), 10); err != nil {
+		t.Errorf("pending once quarantined: %v", err)
+	}
+
+	// Corrupt files do not prevent the queue from being opened.
+	must(q.Enqueue(ctx, []*ReceivedEvent{{Topic: "orders", Type: "create", URL: "https://orders/4"}}))
+	must(os.WriteFile(q.path(4), nil, 0o644))
+	q, err = NewFileQueue(dir)
+	must(err)
+	if got := q.Len(); got != 0 {
+		t.Errorf("len after reopening: got %d, want %d", got, 0)
+	}
+	if _, err := q.Pending(ctx, time.Now(), 10); !errors.As(err, &quarantined) {
+		t.Errorf("pending after reopening: got %v, want a *QuarantineError", err)
+	}
+	must(q.Enqueue(ctx, []*ReceivedEvent{{Topic: "orders", Type: "create", URL: "https://orders/5"}}))
+	if batches, err := q.Pending(ctx, time.Now(), 10); err != nil || len(batches) != 1 || batches[0].ID != 5 {
+		t.Errorf("pending: got %+v, %v", batches, err)
+	}
+}
+
+func TestFileQueueRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	q, err := NewFileQueue(dir)
+	must(err)
+	for _, url := range []string{"https://orders/1", "https://orders/2"} {
+		must(q.Enqueue(ctx, []*ReceivedEvent{{Topic: "orders", Type: "create", URL: url}}))
+	}
+	must(os.WriteFile(q.path(2), nil, 0o644))
+
+	// The ID of a quarantined batch is not reused after restarts.
+	for i := 0; i < 2; i++ {
+		q, err = NewFileQueue(dir)
+		must(err)
+	}
+	must(q.Enqueue(ctx, []*ReceivedEvent{{Topic: "orders", Type: "create", URL: "https://orders/3"}}))
+	if _, err := os.Stat(q.path(2)); !os.IsNotExist(err) {
+		t.Errorf("quarantined batch ID reused: %v", err)
+	}
+	if batches, err := q.Pending(ctx, time.Now(), 10); err != nil || len(batches) != 2 || batches[1].ID != 3 {
+		t.Errorf("pending: got %+v, %v", batches, err)
+	}
+}
+
+func TestListenerAsync(t *testing.T) {
+	var (
+		queue    = NewMemoryQueue()
+		fail     = true
+		received []*ReceivedEvent
+		errs     []error
+	)
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error {
+			if fail {
+				return errors.New("failed")
+			}
+			received = append(received, events...)
+			return nil
+		},
+		OnError: func(err error) { errs = append(errs, err) },
+		UUID:    "secret",
+		Async: &AsyncConfig{
+			Queue:          queue,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(
+		`[{"topic":"orders","type":"create","url":"https://orders/1","t":1}]`))
+	req.SetBasicAuth("secret", "")
+	w := httptest.NewRecorder()
+	listener.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if len(received) != 0 || queue.Len() != 1 {
+		t.Fatalf("expected the batch to be queued, got %d received, %d queued", len(received), queue.Len())
+	}
+
+	ctx := context.Background()
+	if _, err := listener.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || queue.Len() != 1 {
+		t.Errorf("expected the failed batch to be kept, got errors %v", errs)
+	}
+
+	fail = false
+	time.Sleep(5 * time.Millisecond)
+	if _, err := listener.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 || queue.Len() != 0 {
+		t.Errorf("expected the batch to be handled, got %d received, %d queued", len(received), queue.Len())
+	}
+
+	t.Run("synchronous", func(t *testing.T) {
+		l := NewListener(&ListenerConfig{UUID: "secret"})
+		if err := l.Run(ctx); err != errNotAsync {
+			t.Errorf("got %v, want %v", err, errNotAsync)
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package routemaster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+)
+
+// Outcome is the result of handling an event.
+type Outcome int
+
+const (
+	// OutcomeAck reports that the event was handled.
+	OutcomeAck Outcome = iota
+
+	// OutcomeRetry reports that the event failed and should be handled
+	// again. Depending on the Listener's configuration, the event is
+	// dead-lettered, re-pushed to the bus, or redelivered with its batch.
+	OutcomeRetry
+
+	// OutcomeDrop reports that the event should be discarded without being
+	// handled.
+	OutcomeDrop
+)
+
+// EventResult is the result of handling one event of a batch.
+type EventResult struct {
+	Outcome Outcome
+
+	// Err explains why the event is retried or dropped. Optional.
+	Err error
+}
+
+// The ResultHandlerFunc type represents a function handling a batch of events
+// one by one. It returns the result of each event, in the order of the
+// events.
+type ResultHandlerFunc func(context.Context, []*ReceivedEvent) []EventResult
+
+// errRetry is the cause of retried events whose result has no error.
+var errRetry = errors.New("routemaster: event to retry")
+
+// AdaptHandler adapts a ContextHandlerFunc into a ResultHandlerFunc: every
+// event is acknowledged if h succeeds, and retried with its error otherwise.
+func AdaptHandler(h ContextHandlerFunc) ResultHandlerFunc {
+	return func(ctx context.Context, events []*ReceivedEvent) []EventResult {
+		result := EventResult{Outcome: OutcomeAck}
+		if err := h(ctx, events); err != nil {
+			result = EventResult{Outcome: OutcomeRetry, Err: err}
+		}
+		return fill(len(events), result)
+	}
+}
+
+// fill returns n copies of a result.
+func fill(n int, result EventResult) []EventResult {
+	results := make([]EventResult, n)
+	for i := range results {
+		results[i] = result
+	}
+	return results
+}
+
+// resultsError joins the errors of the events to retry, or returns nil if
+// there are none.
+func resultsError(results []EventResult) error {
+	var errs []error
+	for _, r := range results {
+		if r.Outcome == OutcomeRetry {
+			err := r.Err
+			if err == nil {
+				err = errRetry
+			}
+			errs = append(errs, err)
+		}
+	}
+	return joinErrors(errs)
+}
+
+// joinErrors is like errors.Join, but reports identical errors, e.g. of the
+// events of an adapted handler, once, and returns a single error as is.
+func joinErrors(errs []error) error {
+	var (
+		unique []error
+		seen   = make(map[error]bool)
+	)
+	for _, err := range errs {
+		if reflect.TypeOf(err).Comparable() {
+			if seen[err] {
+				continue
+			}
+			seen[err] = true
+		}
+		unique = append(unique, err)
+	}
+	if len(unique) == 1 {
+		return unique[0]
+	}
+	return errors.Join(unique...)
+}
+
+// checkResults turns the results of a handler that does not return one
+// result per event into retries.
+func checkResults(events []*ReceivedEvent, results []EventResult) []EventResult {
+	if len(results) == len(events) {
+		return results
+	}
+	err := fmt.Errorf("routemaster: handler returned %d results for %d events", len(results), len(events))
+	return fill(len(events), EventResult{Outcome: OutcomeRetry, Err: err})
+}
+
+// resolve applies the results of a batch: events to retry are dead-lettered
+// or re-pushed if configured. It returns which events are done with, i.e.
+// resolved without being re-pushed, and the errors of the events left to be
+// redelivered.
+func (l *Listener) resolve(ctx context.Context, events []*ReceivedEvent, results []EventResult) ([]bool, error) {
+	var (
+		done = make([]bool, len(events))
+		errs []error
+	)
+	for i, e := range events {
+		switch results[i].Outcome {
+		case OutcomeRetry:
+			err := results[i].Err
+			if err == nil {
+				err = errRetry
+			}
+			repushed, err := l.retry(ctx, e, err)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if err != nil || repushed {
+				continue
+			}
+		case OutcomeDrop:
+			attrs := []slog.Attr{
+				slog.String("topic", e.Topic),
+				slog.String("type", e.Type),
+				slog.String("url", e.URL),
+			}
+			if err := results[i].Err; err != nil {
+				attrs = append(attrs, slog.Any("error", err))
+			}
+			l.logger.LogAttrs(ctx, slog.LevelDebug, "routemaster event dropped", attrs...)
+		}
+		done[i] = true
+	}
+	return done, joinErrors(errs)
+}
+
+// retry dead-letters an event that has failed too many times, or re-pushes
+// it to the bus, reporting whether it was re-pushed. It returns an error if
+// the event is left to be redelivered with its batch.
+func (l *Listener) retry(ctx context.Context, e *ReceivedEvent, err error) (bool, error) {
+	if l.deadLetter != nil {
+		if err = l.recordFailure(ctx, e, err); err == nil {
+			return false, nil
+		}
+	}
+	if l.repush != nil {
+		if pushErr := l.repush.PushContext(ctx, e.Topic, repushed(e)); pushErr != nil {
+			return false, fmt.Errorf("re-pushing failed: %w (after: %w)", pushErr, err)
+		}
+		return true, nil
+	}
+	return false, err
+}
+
+// repushed returns the event to push to retry a received event.
+func repushed(e *ReceivedEvent) *Event {
+	event := &Event{
+		Type:      e.Type,
+		URL:       e.URL,
+		Timestamp: e.Timestamp,
+	}
+	if len(e.Data) > 0 {
+		event.Data = e.Data
+	}
+	return event
+}
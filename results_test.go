@@ -0,0 +1,208 @@
+package routemaster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const resultsBatch = `[
+	{"topic":"orders","type":"update","url":"https://orders/ack","t":1},
+	{"topic":"orders","type":"update","url":"https://orders/retry","t":2,"data":{"id":2}},
+	{"topic":"orders","type":"update","url":"https://orders/drop","t":3}
+]`
+
+// outcomesByURL returns a handler whose outcomes depend on the URL of events,
+// recording the URLs of the events it is invoked with.
+func outcomesByURL(handled *[]string) ResultHandlerFunc {
+	return func(ctx context.Context, events []*ReceivedEvent) []EventResult {
+		results := make([]EventResult, len(events))
+		for i, e := range events {
+			*handled = append(*handled, e.URL)
+			switch e.URL {
+			case "https://orders/retry":
+				results[i] = EventResult{Outcome: OutcomeRetry, Err: errors.New("failed")}
+			case "https://orders/drop":
+				results[i] = EventResult{Outcome: OutcomeDrop}
+			}
+		}
+		return results
+	}
+}
+
+func postBatch(l *Listener, body string) int {
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	req.SetBasicAuth("secret", "")
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestListenerResults(t *testing.T) {
+	t.Run("redelivery", func(t *testing.T) {
+		var handled []string
+		listener := NewListener(&ListenerConfig{
+			ResultHandler: outcomesByURL(&handled),
+			OnError:       func(error) {},
+			UUID:          "secret",
+			Dedup:         NewMemoryDedupStore(100, time.Minute),
+		})
+		for i := 0; i < 2; i++ {
+			if code := postBatch(listener, resultsBatch); code != http.StatusInternalServerError {
+				t.Errorf("delivery %d: got %d, want %d", i, code, http.StatusInternalServerError)
+			}
+		}
+		// Resolved events are dropped when the batch is redelivered.
+		want := []string{"https://orders/ack", "https://orders/retry", "https://orders/drop", "https://orders/retry"}
+		if !reflect.DeepEqual(handled, want) {
+			t.Errorf("handled: got %v, want %v", handled, want)
+		}
+	})
+
+	t.Run("repush", func(t *testing.T) {
+		var (
+			fail   bool
+			pushes []map[string]interface{}
+		)
+		bus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			var push map[string]interface{}
+			b, _ := ioutil.ReadAll(r.Body)
+			must(json.Unmarshal(b, &push))
+			push["path"] = r.URL.Path
+			pushes = append(pushes, push)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer bus.Close()
+		client, err := NewClient(&Config{URL: bus.URL, UUID: "demo"})
+		must(err)
+
+		var handled []string
+		listener := NewListener(&ListenerConfig{
+			ResultHandler: outcomesByURL(&handled),
+			OnError:       func(error) {},
+			UUID:          "secret",
+			Repush:        client,
+		})
+		if code := postBatch(listener, resultsBatch); code != http.StatusOK {
+			t.Errorf("status: got %d, want %d", code, http.StatusOK)
+		}
+		want := []map[string]interface{}{{
+			"path":      "/topics/orders",
+			"type":      "update",
+			"url":       "https://orders/retry",
+			"timestamp": float64(2),
+			"data":      map[string]interface{}{"id": float64(2)},
+		}}
+		if !reflect.DeepEqual(pushes, want) {
+			t.Errorf("pushes: got %v, want %v", pushes, want)
+		}
+
+		fail = true
+		if code := postBatch(listener, resultsBatch); code != http.StatusInternalServerError {
+			t.Errorf("status with failed push: got %d, want %d", code, http.StatusInternalServerError)
+		}
+	})
+
+	t.Run("repush with dedup", func(t *testing.T) {
+		var pushes int
+		bus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pushes++
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer bus.Close()
+		client, err := NewClient(&Config{URL: bus.URL, UUID: "demo"})
+		must(err)
+
+		var handled []string
+		listener := NewListener(&ListenerConfig{
+			ResultHandler: outcomesByURL(&handled),
+			OnError:       func(error) {},
+			UUID:          "secret",
+			Repush:        client,
+			Dedup:         NewMemoryDedupStore(100, time.Minute),
+		})
+		if code := postBatch(listener, resultsBatch); code != http.StatusOK {
+			t.Errorf("status: got %d, want %d", code, http.StatusOK)
+		}
+		// The re-pushed event is handled when the bus delivers it again, the
+		// others are dropped.
+		if code := postBatch(listener, resultsBatch); code != http.StatusOK {
+			t.Errorf("redelivery status: got %d, want %d", code, http.StatusOK)
+		}
+		want := []string{"https://orders/ack", "https://orders/retry", "https://orders/drop", "https://orders/retry"}
+		if !reflect.DeepEqual(handled, want) {
+			t.Errorf("handled: got %v, want %v", handled, want)
+		}
+		if pushes != 2 {
+			t.Errorf("pushes: got %d, want %d", pushes, 2)
+		}
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		var (
+			handled []string
+			sink    = NewMemoryDeadLetterSink()
+		)
+		listener := NewListener(&ListenerConfig{
+			ResultHandler: outcomesByURL(&handled),
+			UUID:          "secret",
+			DeadLetter:    &DeadLetterConfig{Sink: sink, MaxFailures: 1},
+		})
+		if code := postBatch(listener, resultsBatch); code != http.StatusOK {
+			t.Errorf("status: got %d, want %d", code, http.StatusOK)
+		}
+		// Events are not handled one at a time, since their outcomes are
+		// known.
+		if len(handled) != 3 {
+			t.Errorf("handled: got %v", handled)
+		}
+		if letters := sink.Letters(); len(letters) != 1 || letters[0].Event.URL != "https://orders/retry" {
+			t.Errorf("dead letters: got %+v", letters)
+		}
+	})
+
+	t.Run("missing results", func(t *testing.T) {
+		listener := NewListener(&ListenerConfig{
+			ResultHandler: func(ctx context.Context, events []*ReceivedEvent) []EventResult {
+				return nil
+			},
+			OnError: func(error) {},
+			UUID:    "secret",
+		})
+		if code := postBatch(listener, resultsBatch); code != http.StatusInternalServerError {
+			t.Errorf("status: got %d, want %d", code, http.StatusInternalServerError)
+		}
+	})
+}
+
+func TestAdaptHandler(t *testing.T) {
+	events := []*ReceivedEvent{{URL: "https://orders/1"}, {URL: "https://orders/2"}}
+	errFailed := errors.New("failed")
+	tests := []struct {
+		err  error
+		want []EventResult
+	}{
+		{nil, []EventResult{{Outcome: OutcomeAck}, {Outcome: OutcomeAck}}},
+		{errFailed, []EventResult{{Outcome: OutcomeRetry, Err: errFailed}, {Outcome: OutcomeRetry, Err: errFailed}}},
+	}
+	for _, tt := range tests {
+		h := AdaptHandler(func(context.Context, []*ReceivedEvent) error { return tt.err })
+		if got := h(context.Background(), events); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.err, got, tt.want)
+		}
+		if got := resultsError(h(context.Background(), events)); got != tt.err {
+			t.Errorf("%v: error: got %v, want %v", tt.err, got, tt.err)
+		}
+	}
+}
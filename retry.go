@@ -0,0 +1,206 @@
+package routemaster
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Defaults applied to the zero fields of a RetryPolicy.
+const (
+	defaultMaxAttempts    = 3
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+)
+
+// defaultRetryableStatusCodes are the response codes retried when
+// RetryPolicy.RetryableStatusCodes is nil.
+var defaultRetryableStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy specifies how a Client retries failed requests. Zero fields take
+// their documented defaults.
+//
+// By default only idempotent requests (GET and DELETE, e.g. GetTopics,
+// DeleteTopic and Unsubscribe) are retried. Requests that may have side
+// effects if repeated, such as Push, are only retried if RetryNonIdempotent
+// is set.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts made for a request,
+	// including the first one. Defaults to 3.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry. It doubles after
+	// every subsequent attempt, and is randomised by up to half its value to
+	// avoid synchronised retries. Defaults to 100ms.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between two attempts, including delays
+	// requested by the bus with a Retry-After header. Defaults to 5s.
+	MaxBackoff time.Duration
+
+	// RetryableStatusCodes are the HTTP status codes that trigger a retry.
+	// Defaults to 429, 502, 503 and 504.
+	RetryableStatusCodes []int
+
+	// IsRetryableError reports whether a transport error (one that occurred
+	// before a response was received) triggers a retry. Defaults to retrying
+	// timeouts, refused and reset connections, and unexpected EOFs.
+	IsRetryableError func(error) bool
+
+	// RetryNonIdempotent enables retries of POST requests such as Push,
+	// Subscribe and CreateToken. A retried Push may result in the event
+	// being published more than once.
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy returns a RetryPolicy with all fields set to their
+// defaults.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:          defaultMaxAttempts,
+		InitialBackoff:       defaultInitialBackoff,
+		MaxBackoff:           defaultMaxBackoff,
+		RetryableStatusCodes: defaultRetryableStatusCodes,
+		IsRetryableError:     isRetryableError,
+	}
+}
+
+// shouldRetry reports whether a request that has been attempted attempt times
+// should be attempted again. Exactly one of statusCode and err is set. A nil
+// policy never retries.
+func (p *RetryPolicy) shouldRetry(method string, attempt int, statusCode int, err error) bool {
+	if p == nil {
+		return false
+	}
+	maxAttempts := p.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	if attempt >= maxAttempts {
+		return false
+	}
+	if !p.RetryNonIdempotent && !isIdempotent(method) {
+		return false
+	}
+	if err != nil {
+		isRetryable := p.IsRetryableError
+		if isRetryable == nil {
+			isRetryable = isRetryableError
+		}
+		return isRetryable(err)
+	}
+	codes := p.RetryableStatusCodes
+	if codes == nil {
+		codes = defaultRetryableStatusCodes
+	}
+	for _, code := range codes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns how long to wait before the next attempt. A Retry-After
+// header in the given response headers takes precedence over the computed
+// exponential backoff, but is capped at MaxBackoff all the same.
+func (p *RetryPolicy) backoff(attempt int, header http.Header) time.Duration {
+	if d, ok := parseRetryAfter(header); ok {
+		max := p.MaxBackoff
+		if max == 0 {
+			max = defaultMaxBackoff
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+	return exponentialBackoff(p.InitialBackoff, p.MaxBackoff, attempt)
+}
+
+// exponentialBackoff returns the delay before the attempt following the given
+// one: initial, doubled after every attempt and capped at max, then
+// randomised by up to half its value. Zero durations take the RetryPolicy
+// defaults.
+func exponentialBackoff(initial, max time.Duration, attempt int) time.Duration {
+	if initial == 0 {
+		initial = defaultInitialBackoff
+	}
+	if max == 0 {
+		max = defaultMaxBackoff
+	}
+	d := initial
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// parseRetryAfter parses a Retry-After header, given either as a number of
+// seconds or as an HTTP date.
+func parseRetryAfter(header http.Header) (time.Duration, bool) {
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// isIdempotent reports whether requests with the given method can safely be
+// repeated.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isRetryableError is the default RetryPolicy.IsRetryableError.
+func isRetryableError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// sleep waits for d to elapse, returning early with the context's error if
+// ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
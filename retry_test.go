@@ -0,0 +1,153 @@
+package routemaster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	push := func(c *Client) error {
+		return c.Push("orders", &Event{Type: "create", URL: "https://orders/1"})
+	}
+	deleteTopic := func(c *Client) error {
+		return c.DeleteTopic("orders")
+	}
+	tests := []struct {
+		name         string
+		run          func(*Client) error
+		policy       *RetryPolicy
+		failures     int
+		failStatus   int
+		retryAfter   string
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{
+			name:         "no policy",
+			run:          deleteTopic,
+			failures:     1,
+			failStatus:   http.StatusBadGateway,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "idempotent request",
+			run:          deleteTopic,
+			policy:       &RetryPolicy{InitialBackoff: time.Millisecond},
+			failures:     2,
+			failStatus:   http.StatusBadGateway,
+			wantAttempts: 3,
+		},
+		{
+			name:         "attempts exhausted",
+			run:          deleteTopic,
+			policy:       &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+			failures:     2,
+			failStatus:   http.StatusServiceUnavailable,
+			wantAttempts: 2,
+			wantErr:      true,
+		},
+		{
+			name:         "non-retryable status",
+			run:          deleteTopic,
+			policy:       &RetryPolicy{InitialBackoff: time.Millisecond},
+			failures:     1,
+			failStatus:   http.StatusBadRequest,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "push not retried by default",
+			run:          push,
+			policy:       &RetryPolicy{InitialBackoff: time.Millisecond},
+			failures:     1,
+			failStatus:   http.StatusBadGateway,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "push retried when enabled",
+			run:          push,
+			policy:       &RetryPolicy{InitialBackoff: time.Millisecond, RetryNonIdempotent: true},
+			failures:     1,
+			failStatus:   http.StatusBadGateway,
+			wantAttempts: 2,
+		},
+		{
+			name:         "retry after",
+			run:          deleteTopic,
+			policy:       &RetryPolicy{InitialBackoff: time.Hour},
+			failures:     1,
+			failStatus:   http.StatusTooManyRequests,
+			retryAfter:   "0",
+			wantAttempts: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if n := atomic.AddInt32(&attempts, 1); int(n) <= tt.failures {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(tt.failStatus)
+				}
+			}))
+			defer ts.Close()
+
+			client, err := NewClient(&Config{URL: ts.URL, UUID: "demo", Retry: tt.policy})
+			must(err)
+
+			err = tt.run(client)
+			if tt.wantErr && err == nil {
+				t.Error("expected error")
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("attempts: got %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 150 * time.Millisecond, 300 * time.Millisecond},
+		{10, 150 * time.Millisecond, 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if d := p.backoff(tt.attempt, nil); d < tt.min || d > tt.max {
+			t.Errorf("attempt %d: got %v, want between %v and %v", tt.attempt, d, tt.min, tt.max)
+		}
+	}
+
+	// Retry-After takes precedence, up to MaxBackoff.
+	retryAfterTests := []struct {
+		retryAfter string
+		want       time.Duration
+	}{
+		{"0", 0},
+		{"2", 300 * time.Millisecond},
+		{"86400", 300 * time.Millisecond},
+	}
+	for _, tt := range retryAfterTests {
+		header := http.Header{"Retry-After": []string{tt.retryAfter}}
+		if d := p.backoff(1, header); d != tt.want {
+			t.Errorf("Retry-After %s: got %v, want %v", tt.retryAfter, d, tt.want)
+		}
+	}
+	if d := (&RetryPolicy{}).backoff(1, http.Header{"Retry-After": []string{"3600"}}); d != defaultMaxBackoff {
+		t.Errorf("Retry-After with default MaxBackoff: got %v, want %v", d, defaultMaxBackoff)
+	}
+}
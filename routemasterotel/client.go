@@ -0,0 +1,78 @@
+package routemasterotel
+
+import (
+	"net/http"
+	"strings"
+
+	routemaster "github.com/deliveroo/routemaster-client-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware returns a routemaster.Middleware creating a client span around
+// every request made to the bus, including retries:
+//
+//	client, err := routemaster.NewClient(&routemaster.Config{
+//		URL:        "https://routemaster.dev",
+//		UUID:       "demo",
+//		Middleware: []routemaster.Middleware{routemasterotel.Middleware()},
+//	})
+func Middleware(opts ...Option) routemaster.Middleware {
+	cfg := newConfig(opts)
+	tracer := cfg.tracer()
+	return func(next http.RoundTripper) http.RoundTripper {
+		return routemaster.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			route, topic := parseRoute(req.URL.Path)
+			attrs := []attribute.KeyValue{
+				attribute.String("messaging.system", "routemaster"),
+				attribute.String("http.request.method", req.Method),
+				attribute.String("http.route", route),
+			}
+			if topic != "" {
+				attrs = append(attrs, attribute.String("messaging.destination.name", topic))
+			}
+			ctx, span := tracer.Start(req.Context(), "routemaster "+req.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attrs...))
+			defer span.End()
+
+			req = req.Clone(ctx)
+			cfg.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return nil, err
+			}
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+			if resp.StatusCode >= 400 {
+				span.SetStatus(codes.Error, resp.Status)
+			}
+			return resp, nil
+		})
+	}
+}
+
+// parseRoute returns the route template of a request path, and the topic it
+// refers to if any. API tokens are left out of the route, so that they do not
+// end up in traces.
+func parseRoute(path string) (route, topic string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	n := len(segments)
+	if n >= 2 {
+		switch segments[n-2] {
+		case "topics":
+			if n >= 3 && segments[n-3] == "subscriber" {
+				return "/subscriber/topics/{topic}", segments[n-1]
+			}
+			return "/topics/{topic}", segments[n-1]
+		case "topic":
+			return "/topic/{topic}", segments[n-1]
+		case "api_tokens":
+			return "/api_tokens/{token}", ""
+		}
+	}
+	return "/" + segments[n-1], ""
+}
@@ -0,0 +1,102 @@
+package routemasterotel
+
+import (
+	"context"
+	"net/http"
+
+	routemaster "github.com/deliveroo/routemaster-client-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Listener wraps a routemaster.Listener, or any http.Handler, creating a
+// server span around every delivery. Combined with Handler, the span of each
+// handler invocation is a child of the delivery span:
+//
+//	listener := routemaster.NewListener(&routemaster.ListenerConfig{
+//		ContextHandler: routemasterotel.Handler(handle),
+//		UUID:           "demo",
+//	})
+//	http.Handle("/events", routemasterotel.Listener(listener))
+func Listener(h http.Handler, opts ...Option) http.Handler {
+	cfg := newConfig(opts)
+	tracer := cfg.tracer()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := cfg.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, "routemaster deliver",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("messaging.system", "routemaster"),
+				attribute.String("http.request.method", r.Method),
+			))
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", sw.status))
+		if sw.status >= 400 {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// Handler wraps a handler, creating a consumer span around every invocation.
+// The span is linked to the spans that produced the events of the batch, as
+// carried by their data (see InjectEvent).
+func Handler(h routemaster.ContextHandlerFunc, opts ...Option) routemaster.ContextHandlerFunc {
+	cfg := newConfig(opts)
+	tracer := cfg.tracer()
+	return func(ctx context.Context, events []*routemaster.ReceivedEvent) error {
+		var (
+			links  []trace.Link
+			topics = make(map[string]bool)
+		)
+		for _, e := range events {
+			topics[e.Topic] = true
+			sc := trace.SpanContextFromContext(extract(context.Background(), cfg, e))
+			if sc.IsValid() {
+				links = append(links, trace.Link{SpanContext: sc})
+			}
+		}
+		attrs := []attribute.KeyValue{
+			attribute.String("messaging.system", "routemaster"),
+			attribute.Int("messaging.batch.message_count", len(events)),
+		}
+		if len(topics) == 1 {
+			attrs = append(attrs, attribute.String("messaging.destination.name", events[0].Topic))
+		}
+		ctx, span := tracer.Start(ctx, "routemaster process",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithLinks(links...),
+			trace.WithAttributes(attrs...))
+		defer span.End()
+
+		if err := h(ctx, events); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+		return nil
+	}
+}
+
+// WrapHandler is like Handler, for handlers that do not take a context.
+func WrapHandler(h routemaster.HandlerFunc, opts ...Option) routemaster.ContextHandlerFunc {
+	return Handler(func(_ context.Context, events []*routemaster.ReceivedEvent) error {
+		return h(events)
+	}, opts...)
+}
+
+// statusWriter records the status code written to a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,56 @@
+// Package routemasterotel instruments Routemaster clients and listeners with
+// OpenTelemetry tracing.
+//
+// Middleware traces the requests a routemaster.Client makes to the bus.
+// Listener and Handler trace event deliveries and their handling. Since the
+// bus does not propagate trace context, InjectEvent carries it in the data of
+// pushed events, and Handler links the spans of consumers back to the
+// producers of the events they handle.
+package routemasterotel
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/deliveroo/routemaster-client-go/routemasterotel"
+
+// An Option configures the instrumentation.
+type Option func(*config)
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	propagator     propagation.TextMapPropagator
+}
+
+// WithTracerProvider sets the provider of the tracer creating spans. Defaults
+// to the global provider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = tp
+	}
+}
+
+// WithPropagator sets the propagator used to inject and extract trace
+// context. Defaults to the global propagator.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = p
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		tracerProvider: otel.GetTracerProvider(),
+		propagator:     otel.GetTextMapPropagator(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *config) tracer() trace.Tracer {
+	return c.tracerProvider.Tracer(instrumentationName)
+}
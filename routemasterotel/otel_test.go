@@ -0,0 +1,137 @@
+package routemasterotel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	routemaster "github.com/deliveroo/routemaster-client-go"
+	"github.com/deliveroo/routemaster-client-go/routemastertest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	opts := []Option{
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+		WithPropagator(propagation.TraceContext{}),
+	}
+
+	bus := routemastertest.NewServer()
+	defer bus.Close()
+
+	var received []*routemaster.ReceivedEvent
+	listener := httptest.NewServer(Listener(routemaster.NewListener(&routemaster.ListenerConfig{
+		ContextHandler: WrapHandler(func(events []*routemaster.ReceivedEvent) error {
+			received = events
+			return nil
+		}, opts...),
+		UUID: "secret",
+	}), opts...))
+	defer listener.Close()
+
+	client, err := routemaster.NewClient(&routemaster.Config{
+		URL:        bus.URL,
+		UUID:       "demo",
+		Middleware: []routemaster.Middleware{Middleware(opts...)},
+	})
+	must(err)
+	must(client.Subscribe(&routemaster.Subscription{
+		Topics:   []string{"orders"},
+		Callback: listener.URL,
+		UUID:     "secret",
+	}))
+
+	// Push an event from within a producer span.
+	ctx, producer := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "create order")
+	event, err := InjectEvent(ctx, &routemaster.Event{
+		Type: "create",
+		URL:  "https://orders/1",
+		Data: routemaster.M{"restaurant_id": 123},
+	}, opts...)
+	must(err)
+	must(client.PushContext(ctx, "orders", event))
+	producer.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	must(bus.Flush(ctx))
+
+	// The event data is preserved alongside the trace context.
+	var data map[string]interface{}
+	must(json.Unmarshal(received[0].Data, &data))
+	if data["restaurant_id"] != float64(123) || data[DataKey] == nil {
+		t.Errorf("data: got %v", data)
+	}
+	if sc := trace.SpanContextFromContext(ExtractEvent(context.Background(), received[0], opts...)); sc.TraceID() != producer.SpanContext().TraceID() {
+		t.Errorf("extracted trace: got %s, want %s", sc.TraceID(), producer.SpanContext().TraceID())
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	push, ok := spans["routemaster POST /topics/{topic}"]
+	if !ok {
+		t.Fatalf("no push span in %v", spans)
+	}
+	if push.Parent().SpanID() != producer.SpanContext().SpanID() {
+		t.Error("push span is not a child of the producer span")
+	}
+	if !hasAttribute(push, attribute.String("messaging.destination.name", "orders")) ||
+		!hasAttribute(push, attribute.Int("http.response.status_code", 204)) {
+		t.Errorf("push span attributes: got %v", push.Attributes())
+	}
+
+	deliver, process := spans["routemaster deliver"], spans["routemaster process"]
+	if deliver == nil || process == nil {
+		t.Fatalf("missing listener spans in %v", spans)
+	}
+	if process.Parent().SpanID() != deliver.SpanContext().SpanID() {
+		t.Error("process span is not a child of the deliver span")
+	}
+	if links := process.Links(); len(links) != 1 || links[0].SpanContext.SpanID() != producer.SpanContext().SpanID() {
+		t.Errorf("process span links: got %v, want producer span", links)
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	tests := []struct {
+		path, route, topic string
+	}{
+		{"/topics/orders", "/topics/{topic}", "orders"},
+		{"/prefix/topics/orders", "/topics/{topic}", "orders"},
+		{"/subscriber/topics/orders", "/subscriber/topics/{topic}", "orders"},
+		{"/topic/orders", "/topic/{topic}", "orders"},
+		{"/api_tokens/secret", "/api_tokens/{token}", ""},
+		{"/api_tokens", "/api_tokens", ""},
+		{"/topics", "/topics", ""},
+	}
+	for _, tt := range tests {
+		route, topic := parseRoute(tt.path)
+		if route != tt.route || topic != tt.topic {
+			t.Errorf("%s: got %s, %s, want %s, %s", tt.path, route, topic, tt.route, tt.topic)
+		}
+	}
+}
+
+func hasAttribute(span sdktrace.ReadOnlySpan, want attribute.KeyValue) bool {
+	for _, kv := range span.Attributes() {
+		if kv == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package routemasterotel
+
+import (
+	"context"
+	"encoding/json"
+
+	routemaster "github.com/deliveroo/routemaster-client-go"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// DataKey is the key of the event data object under which trace context is
+// carried.
+const DataKey = "_trace"
+
+// InjectEvent returns a copy of the event whose data carries the trace
+// context of ctx under DataKey. The data of the event must be nil or encode
+// to a JSON object, whose other keys are left untouched; otherwise the event
+// is returned as is.
+func InjectEvent(ctx context.Context, e *routemaster.Event, opts ...Option) (*routemaster.Event, error) {
+	cfg := newConfig(opts)
+	carrier := propagation.MapCarrier{}
+	cfg.propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return e, nil
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if e.Data != nil {
+		raw, err := json.Marshal(e.Data)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+			// Not an object.
+			return e, nil
+		}
+	}
+	traceContext, err := json.Marshal(carrier)
+	if err != nil {
+		return nil, err
+	}
+	fields[DataKey] = traceContext
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	injected := *e
+	injected.Data = json.RawMessage(data)
+	return &injected, nil
+}
+
+// ExtractEvent returns a copy of ctx carrying the trace context found in the
+// data of a received event, if any.
+func ExtractEvent(ctx context.Context, e *routemaster.ReceivedEvent, opts ...Option) context.Context {
+	return extract(ctx, newConfig(opts), e)
+}
+
+func extract(ctx context.Context, cfg *config, e *routemaster.ReceivedEvent) context.Context {
+	var data struct {
+		Trace map[string]string `json:"_trace"`
+	}
+	if len(e.Data) == 0 || json.Unmarshal(e.Data, &data) != nil || len(data.Trace) == 0 {
+		return ctx
+	}
+	return cfg.propagator.Extract(ctx, propagation.MapCarrier(data.Trace))
+}
@@ -0,0 +1,151 @@
+// Package routemasterprom exports the metrics of Routemaster clients and
+// listeners to Prometheus.
+//
+//	metrics, err := routemasterprom.NewMetrics(prometheus.DefaultRegisterer)
+//	if err != nil {
+//		return err
+//	}
+//	client, err := routemaster.NewClient(&routemaster.Config{
+//		URL:     "https://routemaster.dev",
+//		UUID:    "demo",
+//		Metrics: metrics,
+//	})
+//
+// The same Metrics can be shared by any number of clients and listeners.
+package routemasterprom
+
+import (
+	"strconv"
+	"time"
+
+	routemaster "github.com/deliveroo/routemaster-client-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "routemaster"
+
+// Metrics implements routemaster.Metrics with Prometheus collectors.
+type Metrics struct {
+	clientRequests        *prometheus.CounterVec
+	clientRequestDuration *prometheus.HistogramVec
+	deliveries            *prometheus.CounterVec
+	deliveryDuration      prometheus.Histogram
+	batchSize             prometheus.Histogram
+	events                *prometheus.CounterVec
+	handlerDuration       *prometheus.HistogramVec
+	authFailures          prometheus.Counter
+}
+
+var _ routemaster.Metrics = (*Metrics)(nil)
+
+// NewMetrics creates the collectors and registers them with reg.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		clientRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "requests_total",
+			Help:      "Number of requests made to the bus, by method, route and status code.",
+		}, []string{"method", "route", "code"}),
+		clientRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of requests made to the bus.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		deliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "listener",
+			Name:      "deliveries_total",
+			Help:      "Number of deliveries received, by response status code.",
+		}, []string{"code"}),
+		deliveryDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "listener",
+			Name:      "delivery_duration_seconds",
+			Help:      "Duration of deliveries, from request to response.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "listener",
+			Name:      "batch_size",
+			Help:      "Number of events per delivery.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+		}),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "listener",
+			Name:      "events_total",
+			Help:      "Number of events received, by topic.",
+		}, []string{"topic"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "listener",
+			Name:      "handler_duration_seconds",
+			Help:      "Duration of handler invocations, by result.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"result"}),
+		authFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "listener",
+			Name:      "auth_failures_total",
+			Help:      "Number of deliveries rejected because of bad credentials.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{
+		m.clientRequests,
+		m.clientRequestDuration,
+		m.deliveries,
+		m.deliveryDuration,
+		m.batchSize,
+		m.events,
+		m.handlerDuration,
+		m.authFailures,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ClientRequest implements routemaster.Metrics. Requests that got no
+// response are counted with the code "error".
+func (m *Metrics) ClientRequest(method, route string, statusCode int, duration time.Duration) {
+	code := "error"
+	if statusCode != 0 {
+		code = strconv.Itoa(statusCode)
+	}
+	m.clientRequests.WithLabelValues(method, route, code).Inc()
+	m.clientRequestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// ListenerDelivery implements routemaster.Metrics.
+func (m *Metrics) ListenerDelivery(statusCode int, batchSize int, duration time.Duration) {
+	m.deliveries.WithLabelValues(strconv.Itoa(statusCode)).Inc()
+	m.deliveryDuration.Observe(duration.Seconds())
+	if batchSize > 0 {
+		m.batchSize.Observe(float64(batchSize))
+	}
+}
+
+// ListenerEvents implements routemaster.Metrics.
+func (m *Metrics) ListenerEvents(topic string, count int) {
+	m.events.WithLabelValues(topic).Add(float64(count))
+}
+
+// ListenerHandler implements routemaster.Metrics.
+func (m *Metrics) ListenerHandler(duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.handlerDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// ListenerAuthFailure implements routemaster.Metrics.
+func (m *Metrics) ListenerAuthFailure() {
+	m.authFailures.Inc()
+}
@@ -0,0 +1,103 @@
+package routemasterprom
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	routemaster "github.com/deliveroo/routemaster-client-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics, err := NewMetrics(reg)
+	must(err)
+
+	bus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer bus.Close()
+	client, err := routemaster.NewClient(&routemaster.Config{
+		URL:     bus.URL,
+		UUID:    "demo",
+		Metrics: metrics,
+	})
+	must(err)
+	must(client.Push("orders", &routemaster.Event{Type: "create", URL: "https://orders/1"}))
+
+	listener := routemaster.NewListener(&routemaster.ListenerConfig{
+		Handler: func(events []*routemaster.ReceivedEvent) error {
+			return errors.New("failed")
+		},
+		OnError: func(error) {},
+		UUID:    "secret",
+		Metrics: metrics,
+	})
+	for _, username := range []string{"secret", "wrong"} {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(
+			`[{"topic":"orders","type":"create","url":"https://orders/1","t":1}]`))
+		req.SetBasicAuth(username, "")
+		listener.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	counters := []struct {
+		name      string
+		collector prometheus.Collector
+		want      float64
+	}{
+		{"requests", metrics.clientRequests.WithLabelValues(http.MethodPost, "/topics/{topic}", "204"), 1},
+		{"deliveries 500", metrics.deliveries.WithLabelValues("500"), 1},
+		{"deliveries 401", metrics.deliveries.WithLabelValues("401"), 1},
+		{"events", metrics.events.WithLabelValues("orders"), 1},
+		{"auth failures", metrics.authFailures, 1},
+	}
+	for _, c := range counters {
+		if got := testutil.ToFloat64(c.collector); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	histograms := []struct {
+		name string
+		want int
+	}{
+		{"routemaster_client_request_duration_seconds", 1},
+		{"routemaster_listener_delivery_duration_seconds", 1},
+		{"routemaster_listener_batch_size", 1},
+		{"routemaster_listener_handler_duration_seconds", 1},
+	}
+	for _, h := range histograms {
+		if got, err := testutil.GatherAndCount(reg, h.name); err != nil || got != h.want {
+			t.Errorf("%s: got %d series (%v), want %d", h.name, got, err, h.want)
+		}
+	}
+}
+
+func TestClientRequestError(t *testing.T) {
+	metrics, err := NewMetrics(prometheus.NewRegistry())
+	must(err)
+	metrics.ClientRequest(http.MethodGet, "/topics", 0, time.Second)
+	if got := testutil.ToFloat64(metrics.clientRequests.WithLabelValues(http.MethodGet, "/topics", "error")); got != 1 {
+		t.Errorf("got %v, want 1", got)
+	}
+}
+
+func TestNewMetricsDuplicate(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	_, err := NewMetrics(reg)
+	must(err)
+	if _, err := NewMetrics(reg); err == nil {
+		t.Error("expected an error registering metrics twice")
+	}
+}
@@ -0,0 +1,419 @@
+// Package routemastertest implements an in-process fake Routemaster bus for
+// use in tests.
+package routemastertest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	routemaster "github.com/deliveroo/routemaster-client-go"
+)
+
+const (
+	defaultMax           = 100
+	defaultRetryInterval = 100 * time.Millisecond
+)
+
+// A Server is a fake Routemaster bus listening on a local loopback address.
+// It serves the endpoints called by routemaster.Client, keeps topics,
+// subscriptions and API tokens in memory, and delivers pushed events in
+// batches to the callback URL of every subscriber, retrying failed deliveries.
+//
+// Any non-empty basic auth username is accepted as a client UUID.
+// Subscription timeouts are ignored: events are delivered as soon as they are
+// pushed.
+type Server struct {
+	*httptest.Server
+
+	// HTTPClient is used to deliver events to subscribers. It defaults to
+	// http.DefaultClient, and must be set before the first subscription is
+	// made.
+	HTTPClient *http.Client
+
+	// RetryInterval is how long a subscriber's events are held back after a
+	// failed delivery. It defaults to 100ms, and must be set before the first
+	// subscription is made.
+	RetryInterval time.Duration
+
+	mu          sync.Mutex
+	topics      map[string]*topic
+	subscribers map[string]*subscriber
+	tokens      map[string]string
+	deliverers  sync.WaitGroup
+
+	// ctx is cancelled by Close, aborting deliveries in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type topic struct {
+	name      string
+	publisher string
+	events    []*routemaster.ReceivedEvent
+}
+
+type subscriber struct {
+	name     string
+	callback string
+	uuid     string
+	max      int
+	topics   map[string]bool
+	queue    []*routemaster.ReceivedEvent
+	inFlight int
+	kick     chan struct{}
+}
+
+// NewServer starts and returns a new Server. The caller should call Close when
+// finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		topics:      make(map[string]*topic),
+		subscribers: make(map[string]*subscriber),
+		tokens:      make(map[string]string),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Close stops delivering events, aborting deliveries in flight, and shuts
+// down the server.
+func (s *Server) Close() {
+	s.cancel()
+	s.deliverers.Wait()
+	s.Server.Close()
+}
+
+// Events returns every event pushed to the given topic, in order.
+func (s *Server) Events(name string) []*routemaster.ReceivedEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.topics[name]
+	if !ok {
+		return nil
+	}
+	return append([]*routemaster.ReceivedEvent(nil), t.events...)
+}
+
+// Flush waits until every pushed event has been delivered to its
+// subscribers, or ctx is done.
+func (s *Server) Flush(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		var pending int
+		for _, sub := range s.subscribers {
+			pending += len(sub.queue) + sub.inFlight
+		}
+		s.mu.Unlock()
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	client, _, _ := r.BasicAuth()
+	if client == "" {
+		http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// Clients set with Gzip compress their request bodies.
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		body, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "400 Bad Request", http.StatusBadRequest)
+			return
+		}
+		defer body.Close()
+		r.Body = body
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/api_tokens" && r.Method == http.MethodGet:
+		s.getTokens(w)
+	case path == "/api_tokens" && r.Method == http.MethodPost:
+		s.createToken(w, r)
+	case strings.HasPrefix(path, "/api_tokens/") && r.Method == http.MethodDelete:
+		s.deleteToken(w, strings.TrimPrefix(path, "/api_tokens/"))
+	case path == "/subscription" && r.Method == http.MethodPost:
+		s.subscribe(w, r, client)
+	case path == "/subscriber" && r.Method == http.MethodDelete:
+		s.unsubscribe(w, client, "")
+	case strings.HasPrefix(path, "/subscriber/topics/") && r.Method == http.MethodDelete:
+		s.unsubscribe(w, client, strings.TrimPrefix(path, "/subscriber/topics/"))
+	case path == "/topics" && r.Method == http.MethodGet:
+		s.getTopics(w)
+	case strings.HasPrefix(path, "/topics/") && r.Method == http.MethodPost:
+		s.push(w, r, client, strings.TrimPrefix(path, "/topics/"))
+	case strings.HasPrefix(path, "/topic/") && r.Method == http.MethodDelete:
+		s.deleteTopic(w, client, strings.TrimPrefix(path, "/topic/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) getTokens(w http.ResponseWriter) {
+	s.mu.Lock()
+	tokens := make([]*routemaster.Token, 0, len(s.tokens))
+	for token, name := range s.tokens {
+		tokens = append(tokens, &routemaster.Token{Name: name, Token: token})
+	}
+	s.mu.Unlock()
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Token < tokens[j].Token })
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := &routemaster.Token{Name: body.Name, Token: body.Name + "--" + hex.EncodeToString(buf)}
+	s.mu.Lock()
+	s.tokens[token.Token] = token.Name
+	s.mu.Unlock()
+	writeJSON(w, http.StatusCreated, token)
+}
+
+func (s *Server) deleteToken(w http.ResponseWriter, token string) {
+	s.mu.Lock()
+	delete(s.tokens, token)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) subscribe(w http.ResponseWriter, r *http.Request, client string) {
+	var body routemaster.Subscription
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil ||
+		len(body.Topics) == 0 || body.Callback == "" || body.UUID == "" {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscribers[client]
+	if !ok {
+		sub = &subscriber{name: client, kick: make(chan struct{}, 1)}
+		s.subscribers[client] = sub
+		s.deliverers.Add(1)
+		go s.deliver(sub)
+	}
+	sub.callback = body.Callback
+	sub.uuid = body.UUID
+	sub.max = body.Max
+	if sub.max <= 0 {
+		sub.max = defaultMax
+	}
+	sub.topics = make(map[string]bool)
+	for _, name := range body.Topics {
+		sub.topics[name] = true
+		if _, ok := s.topics[name]; !ok {
+			s.topics[name] = &topic{name: name}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) unsubscribe(w http.ResponseWriter, client, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscribers[client]
+	if !ok {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	if name == "" {
+		sub.topics = make(map[string]bool)
+	} else {
+		delete(sub.topics, name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getTopics(w http.ResponseWriter) {
+	s.mu.Lock()
+	topics := make([]*routemaster.Topic, 0, len(s.topics))
+	for _, t := range s.topics {
+		topics = append(topics, &routemaster.Topic{
+			Name:      t.name,
+			Publisher: t.publisher,
+			Events:    len(t.events),
+		})
+	}
+	s.mu.Unlock()
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+	writeJSON(w, http.StatusOK, topics)
+}
+
+func (s *Server) push(w http.ResponseWriter, r *http.Request, client, name string) {
+	var body struct {
+		Type      string          `json:"type"`
+		URL       string          `json:"url"`
+		Timestamp int64           `json:"timestamp"`
+		Data      json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Type == "" || body.URL == "" {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return
+	}
+	if body.Timestamp == 0 {
+		body.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.topics[name]
+	if !ok {
+		t = &topic{name: name}
+		s.topics[name] = t
+	}
+	if t.publisher == "" {
+		t.publisher = client
+	} else if t.publisher != client {
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+	event := &routemaster.ReceivedEvent{
+		Topic:     name,
+		Type:      body.Type,
+		URL:       body.URL,
+		Timestamp: body.Timestamp,
+		Data:      body.Data,
+	}
+	t.events = append(t.events, event)
+	for _, sub := range s.subscribers {
+		if sub.topics[name] {
+			sub.queue = append(sub.queue, event)
+			select {
+			case sub.kick <- struct{}{}:
+			default:
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteTopic(w http.ResponseWriter, client, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.topics[name]
+	if !ok {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	if t.publisher != "" && t.publisher != client {
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+	delete(s.topics, name)
+	for _, sub := range s.subscribers {
+		delete(sub.topics, name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deliver sends the queued events of a subscriber to its callback until the
+// server is closed.
+func (s *Server) deliver(sub *subscriber) {
+	defer s.deliverers.Done()
+	httpClient := s.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	retryInterval := s.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = defaultRetryInterval
+	}
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-sub.kick:
+		case <-retry:
+		}
+		retry = nil
+
+		for {
+			s.mu.Lock()
+			n := len(sub.queue)
+			if n > sub.max {
+				n = sub.max
+			}
+			batch := sub.queue[:n]
+			sub.inFlight = n
+			callback, uuid := sub.callback, sub.uuid
+			s.mu.Unlock()
+			if n == 0 {
+				break
+			}
+
+			err := post(s.ctx, httpClient, callback, uuid, batch)
+
+			s.mu.Lock()
+			sub.inFlight = 0
+			if err == nil {
+				sub.queue = sub.queue[n:]
+			}
+			s.mu.Unlock()
+			if err != nil {
+				retry = time.After(retryInterval)
+				break
+			}
+		}
+	}
+}
+
+func post(ctx context.Context, client *http.Client, callback, uuid string, events []*routemaster.ReceivedEvent) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callback, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(uuid, "x")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("routemastertest: delivery to %s failed: %s", callback, resp.Status)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
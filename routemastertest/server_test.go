@@ -0,0 +1,152 @@
+package routemastertest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	routemaster "github.com/deliveroo/routemaster-client-go"
+)
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	bus := NewServer()
+	defer bus.Close()
+
+	var (
+		mu       sync.Mutex
+		received []*routemaster.ReceivedEvent
+		fail     = true
+	)
+	listener := httptest.NewServer(routemaster.NewListener(&routemaster.ListenerConfig{
+		Handler: func(events []*routemaster.ReceivedEvent) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if fail {
+				fail = false
+				return http.ErrHandlerTimeout
+			}
+			received = append(received, events...)
+			return nil
+		},
+		OnError: func(error) {},
+		UUID:    "secret",
+	}))
+	defer listener.Close()
+	bus.RetryInterval = time.Millisecond
+
+	subscriber, err := routemaster.NewClient(&routemaster.Config{URL: bus.URL, UUID: "consumer"})
+	must(err)
+	publisher, err := routemaster.NewClient(&routemaster.Config{URL: bus.URL, UUID: "producer"})
+	must(err)
+
+	must(subscriber.Subscribe(&routemaster.Subscription{
+		Topics:   []string{"orders"},
+		Callback: listener.URL,
+		UUID:     "secret",
+		Max:      1,
+	}))
+	must(publisher.Push("orders", &routemaster.Event{Type: "create", URL: "https://orders/1"}))
+	must(publisher.Push("orders", &routemaster.Event{Type: "update", URL: "https://orders/1"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	must(bus.Flush(ctx))
+
+	mu.Lock()
+	if len(received) != 2 || received[0].Type != "create" || received[1].Type != "update" {
+		t.Errorf("received: got %+v, want create and update", received)
+	}
+	mu.Unlock()
+
+	topics, err := publisher.GetTopics()
+	must(err)
+	if len(topics) != 1 || topics[0].Publisher != "producer" || topics[0].Events != 2 {
+		t.Errorf("topics: got %+v", topics)
+	}
+	if err := subscriber.Push("orders", &routemaster.Event{Type: "noop", URL: "https://orders/1"}); err == nil {
+		t.Error("expected push from another publisher to fail")
+	}
+
+	must(subscriber.Unsubscribe("orders"))
+	must(publisher.Push("orders", &routemaster.Event{Type: "delete", URL: "https://orders/1"}))
+	must(bus.Flush(ctx))
+	if got := len(bus.Events("orders")); got != 3 {
+		t.Errorf("events: got %d, want %d", got, 3)
+	}
+	mu.Lock()
+	if len(received) != 2 {
+		t.Errorf("received after unsubscribe: got %d events, want %d", len(received), 2)
+	}
+	mu.Unlock()
+
+	token, err := publisher.CreateToken("ci")
+	must(err)
+	tokens, err := publisher.GetTokens()
+	must(err)
+	if len(tokens) != 1 || tokens[0].Token != token {
+		t.Errorf("tokens: got %+v, want %s", tokens, token)
+	}
+	must(publisher.DeleteToken(token))
+	must(publisher.DeleteTopic("orders"))
+	if topics, _ := publisher.GetTopics(); len(topics) != 0 {
+		t.Errorf("topics after delete: got %+v", topics)
+	}
+}
+
+func TestServerGzip(t *testing.T) {
+	bus := NewServer()
+	defer bus.Close()
+
+	client, err := routemaster.NewClient(&routemaster.Config{URL: bus.URL, UUID: "producer", Gzip: true})
+	must(err)
+	must(client.Subscribe(&routemaster.Subscription{
+		Topics:   []string{"orders"},
+		Callback: "https://consumer.dev/events",
+		UUID:     "secret",
+	}))
+	must(client.Push("orders", &routemaster.Event{Type: "create", URL: "https://orders/1"}))
+	if events := bus.Events("orders"); len(events) != 1 || events[0].Type != "create" {
+		t.Errorf("events: got %+v, want create", events)
+	}
+}
+
+func TestServerCloseWhileDelivering(t *testing.T) {
+	bus := NewServer()
+	started, unblock := make(chan struct{}, 1), make(chan struct{})
+	listener := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+	}))
+	defer listener.Close()
+	defer close(unblock)
+
+	client, err := routemaster.NewClient(&routemaster.Config{URL: bus.URL, UUID: "demo"})
+	must(err)
+	must(client.Subscribe(&routemaster.Subscription{
+		Topics:   []string{"orders"},
+		Callback: listener.URL,
+		UUID:     "secret",
+	}))
+	must(client.Push("orders", &routemaster.Event{Type: "create", URL: "https://orders/1"}))
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		bus.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close did not return while a delivery was blocked")
+	}
+}
@@ -0,0 +1,91 @@
+package routemaster
+
+import (
+	"sort"
+)
+
+// SquashConfig specifies which events a Listener squashes. See Squash.
+type SquashConfig struct {
+	// Topics lists the topics whose events are squashed. If empty, events of
+	// all topics are squashed.
+	Topics []string
+}
+
+// Squash collapses the events of a batch that refer to the same entity (the
+// same topic and URL) into at most one event, so that handlers only see the
+// entity's latest state:
+//
+//   - the event with the latest timestamp wins; events with equal
+//     timestamps are ordered as received;
+//   - noop events are ignored, unless the entity has only noop events;
+//   - a delete event dominates the updates before it;
+//   - an entity created in the batch is reported as created, with the data
+//     of its latest event, unless it is also deleted, in which case its
+//     events cancel out.
+//
+// The resulting events keep the relative order in which their winning event
+// was received. Events are not modified; events whose type changes are
+// copied.
+func Squash(events []*ReceivedEvent) []*ReceivedEvent {
+	return squash(events, nil)
+}
+
+// squash squashes the events of the given topics, or of all topics if topics
+// is nil.
+func squash(events []*ReceivedEvent, topics map[string]bool) []*ReceivedEvent {
+	type entityKey struct{ topic, url string }
+	type indexedEvent struct {
+		index int
+		event *ReceivedEvent
+	}
+
+	var (
+		groups = make(map[entityKey][]indexedEvent)
+		kept   []indexedEvent
+	)
+	for i, e := range events {
+		if topics != nil && !topics[e.Topic] {
+			kept = append(kept, indexedEvent{i, e})
+			continue
+		}
+		key := entityKey{e.Topic, e.URL}
+		groups[key] = append(groups[key], indexedEvent{i, e})
+	}
+
+	for _, group := range groups {
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].event.Timestamp < group[j].event.Timestamp
+		})
+
+		// Ignore noops, unless there is nothing else.
+		changes := group[:0:0]
+		for _, ie := range group {
+			if ie.event.Type != "noop" {
+				changes = append(changes, ie)
+			}
+		}
+		if len(changes) == 0 {
+			kept = append(kept, group[len(group)-1])
+			continue
+		}
+
+		first, last := changes[0], changes[len(changes)-1]
+		switch {
+		case first.event.Type == "create" && last.event.Type == "delete":
+			// Created and deleted within the batch: nothing happened.
+		case first.event.Type == "create" && last.event.Type != "create":
+			created := *last.event
+			created.Type = "create"
+			kept = append(kept, indexedEvent{last.index, &created})
+		default:
+			kept = append(kept, last)
+		}
+	}
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].index < kept[j].index })
+	result := make([]*ReceivedEvent, len(kept))
+	for i, ie := range kept {
+		result[i] = ie.event
+	}
+	return result
+}
@@ -0,0 +1,116 @@
+package routemaster
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSquash(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []*ReceivedEvent
+		want   []string
+	}{
+		{
+			name: "latest update wins",
+			events: []*ReceivedEvent{
+				{Topic: "orders", Type: "update", URL: "https://orders/1", Timestamp: 2},
+				{Topic: "orders", Type: "update", URL: "https://orders/2", Timestamp: 1},
+				{Topic: "orders", Type: "update", URL: "https://orders/1", Timestamp: 1},
+			},
+			want: []string{"update https://orders/1 2", "update https://orders/2 1"},
+		},
+		{
+			name: "delete dominates updates",
+			events: []*ReceivedEvent{
+				{Topic: "orders", Type: "update", URL: "https://orders/1", Timestamp: 1},
+				{Topic: "orders", Type: "delete", URL: "https://orders/1", Timestamp: 2},
+				{Topic: "orders", Type: "noop", URL: "https://orders/1", Timestamp: 3},
+			},
+			want: []string{"delete https://orders/1 2"},
+		},
+		{
+			name: "create and delete cancel out",
+			events: []*ReceivedEvent{
+				{Topic: "orders", Type: "create", URL: "https://orders/1", Timestamp: 1},
+				{Topic: "orders", Type: "update", URL: "https://orders/1", Timestamp: 2},
+				{Topic: "orders", Type: "delete", URL: "https://orders/1", Timestamp: 3},
+			},
+			want: []string{},
+		},
+		{
+			name: "create then update",
+			events: []*ReceivedEvent{
+				{Topic: "orders", Type: "update", URL: "https://orders/1", Timestamp: 2},
+				{Topic: "orders", Type: "create", URL: "https://orders/1", Timestamp: 1},
+			},
+			want: []string{"create https://orders/1 2"},
+		},
+		{
+			name: "noops only",
+			events: []*ReceivedEvent{
+				{Topic: "orders", Type: "noop", URL: "https://orders/1", Timestamp: 1},
+				{Topic: "orders", Type: "noop", URL: "https://orders/1", Timestamp: 2},
+			},
+			want: []string{"noop https://orders/1 2"},
+		},
+		{
+			name: "topics are separate",
+			events: []*ReceivedEvent{
+				{Topic: "orders", Type: "update", URL: "https://shared/1", Timestamp: 1},
+				{Topic: "riders", Type: "update", URL: "https://shared/1", Timestamp: 2},
+			},
+			want: []string{"update https://shared/1 1", "update https://shared/1 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, e := range Squash(tt.events) {
+				got = append(got, fmt.Sprintf("%s %s %d", e.Type, e.URL, e.Timestamp))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The original events are not modified.
+	e := &ReceivedEvent{Topic: "orders", Type: "update", URL: "https://orders/1", Timestamp: 2}
+	Squash([]*ReceivedEvent{{Topic: "orders", Type: "create", URL: "https://orders/1", Timestamp: 1}, e})
+	if e.Type != "update" {
+		t.Errorf("type: got %q, want %q", e.Type, "update")
+	}
+}
+
+func TestListenerSquash(t *testing.T) {
+	var received []*ReceivedEvent
+	listener := NewListener(&ListenerConfig{
+		Handler: func(events []*ReceivedEvent) error {
+			received = events
+			return nil
+		},
+		UUID:   "secret",
+		Squash: &SquashConfig{Topics: []string{"orders"}},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`[
+		{"topic": "orders", "type": "update", "url": "https://orders/1", "t": 1},
+		{"topic": "riders", "type": "update", "url": "https://riders/1", "t": 1},
+		{"topic": "orders", "type": "update", "url": "https://orders/1", "t": 2},
+		{"topic": "riders", "type": "update", "url": "https://riders/1", "t": 2}
+	]`))
+	req.SetBasicAuth("secret", "")
+	w := httptest.NewRecorder()
+	listener.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want %d", w.Code, http.StatusOK)
+	}
+	if len(received) != 3 {
+		t.Errorf("received: got %d events, want %d", len(received), 3)
+	}
+}
@@ -0,0 +1,117 @@
+package routemaster
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ClientTLSConfig specifies how a Client connects to the bus over TLS.
+type ClientTLSConfig struct {
+	// CAFile is the path of a PEM bundle of root certificates used to verify
+	// the bus, instead of the system roots. Optional.
+	CAFile string
+
+	// CertFile and KeyFile are the paths of the PEM certificate and key
+	// presented to the bus for mutual TLS. Optional.
+	CertFile string
+	KeyFile  string
+
+	// MinVersion is the minimum TLS version accepted, e.g.
+	// tls.VersionTLS13. Defaults to TLS 1.2.
+	MinVersion uint16
+
+	// ServerName overrides the host name used to verify the bus
+	// certificate. Optional.
+	ServerName string
+}
+
+// Build returns the equivalent *tls.Config.
+func (c *ClientTLSConfig) Build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: c.MinVersion,
+		ServerName: c.ServerName,
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	if c.CAFile != "" {
+		pool, err := loadCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("routemaster: loading client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// ServerTLSConfig specifies how a Listener is served over TLS.
+type ServerTLSConfig struct {
+	// CertFile and KeyFile are the paths of the PEM certificate and key of
+	// the server.
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is the path of a PEM bundle of certificates used to
+	// verify the certificate the bus presents. Optional; if set, requests
+	// without a valid client certificate are rejected during the handshake.
+	ClientCAFile string
+
+	// MinVersion is the minimum TLS version accepted, e.g.
+	// tls.VersionTLS13. Defaults to TLS 1.2.
+	MinVersion uint16
+}
+
+// Build returns the equivalent *tls.Config, to be set as the TLSConfig of the
+// http.Server serving a Listener:
+//
+//	tlsConfig, err := (&routemaster.ServerTLSConfig{...}).Build()
+//	srv := &http.Server{Addr: ":8443", Handler: listener, TLSConfig: tlsConfig}
+//	srv.ListenAndServeTLS("", "")
+func (c *ServerTLSConfig) Build() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("routemaster: CertFile and KeyFile must be set")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("routemaster: loading server certificate: %v", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   c.MinVersion,
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	if c.ClientCAFile != "" {
+		pool, err := loadCertPool(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// loadCertPool reads a PEM bundle into a new certificate pool.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("routemaster: reading CA bundle: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("routemaster: no certificates found in %s", path)
+	}
+	return pool, nil
+}
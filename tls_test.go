@@ -0,0 +1,125 @@
+package routemaster
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for TLS tests, writing them to a temporary
+// directory.
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	ca := &testCA{dir: t.TempDir()}
+	ca.cert, ca.key, ca.file, _ = ca.issue("ca", nil)
+	return ca
+}
+
+// issue creates a certificate signed by the CA (or self-signed if the CA has
+// no certificate yet), and returns the paths of its PEM files.
+func (ca *testCA) issue(name string, usage []x509.ExtKeyUsage) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	must(err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  usage,
+		DNSNames:     []string{"bus.test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := tmpl, key
+	if ca.cert == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	must(err)
+	cert, err := x509.ParseCertificate(der)
+	must(err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	must(err)
+
+	certFile := filepath.Join(ca.dir, name+".crt")
+	keyFile := filepath.Join(ca.dir, name+".key")
+	must(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	must(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return cert, key, certFile, keyFile
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	_, _, serverCert, serverKey := ca.issue("server", []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+	_, _, clientCert, clientKey := ca.issue("client", []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth})
+
+	serverTLS, err := (&ServerTLSConfig{
+		CertFile:     serverCert,
+		KeyFile:      serverKey,
+		ClientCAFile: ca.file,
+	}).Build()
+	must(err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = serverTLS
+	ts.StartTLS()
+	defer ts.Close()
+
+	tests := []struct {
+		name  string
+		tls   *ClientTLSConfig
+		valid bool
+	}{
+		{"system roots", nil, false},
+		{"no client certificate", &ClientTLSConfig{CAFile: ca.file}, false},
+		{"wrong server name", &ClientTLSConfig{
+			CAFile:     ca.file,
+			CertFile:   clientCert,
+			KeyFile:    clientKey,
+			ServerName: "other.test",
+		}, false},
+		{"client certificate", &ClientTLSConfig{
+			CAFile:     ca.file,
+			CertFile:   clientCert,
+			KeyFile:    clientKey,
+			ServerName: "bus.test",
+			MinVersion: tls.VersionTLS13,
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(&Config{URL: ts.URL, UUID: "demo", TLS: tt.tls})
+			must(err)
+			err = client.DeleteTopic("orders")
+			if tt.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if !tt.valid && err == nil {
+				t.Error("expected TLS error")
+			}
+		})
+	}
+
+	if _, err := NewClient(&Config{URL: ts.URL, UUID: "demo", TLS: &ClientTLSConfig{CAFile: serverKey}}); err == nil {
+		t.Error("expected error for invalid CA bundle")
+	}
+}
@@ -0,0 +1,195 @@
+package routemaster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A TokenSource returns the token a Client authenticates with, i.e. its UUID.
+// It is called for every request attempt, so that tokens can be rotated
+// without rebuilding clients. Implementations must be safe for concurrent use.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenFunc adapts a function into a TokenSource.
+type TokenFunc func(ctx context.Context) (string, error)
+
+// Token implements TokenSource.
+func (f TokenFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticToken returns a TokenSource always returning token.
+func StaticToken(token string) TokenSource {
+	return TokenFunc(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// A TokenSwitcher is a TokenSource whose token can be replaced, as done by
+// Client.RotateToken.
+type TokenSwitcher interface {
+	TokenSource
+	SwitchToken(ctx context.Context, token string) error
+}
+
+// SwitchableToken is a TokenSwitcher holding its token in memory. Clients
+// sharing a SwitchableToken switch tokens together.
+type SwitchableToken struct {
+	mu    sync.RWMutex
+	token string
+}
+
+// NewSwitchableToken creates a SwitchableToken returning token.
+func NewSwitchableToken(token string) *SwitchableToken {
+	return &SwitchableToken{token: token}
+}
+
+// Token implements TokenSource.
+func (t *SwitchableToken) Token(context.Context) (string, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.token, nil
+}
+
+// SwitchToken implements TokenSwitcher.
+func (t *SwitchableToken) SwitchToken(_ context.Context, token string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = token
+	return nil
+}
+
+// FileToken is a TokenSwitcher reading its token from a file, which is read
+// again whenever its modification time changes. Surrounding whitespace is
+// ignored. Switching tokens rewrites the file, so that every process reading
+// it switches over.
+type FileToken struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	token   string
+}
+
+// NewFileToken creates a FileToken reading the file at path.
+func NewFileToken(path string) *FileToken {
+	return &FileToken{path: path}
+}
+
+// Token implements TokenSource.
+func (t *FileToken) Token(context.Context) (string, error) {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && info.ModTime().Equal(t.modTime) {
+		return t.token, nil
+	}
+	b, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("routemaster: %s is empty", t.path)
+	}
+	t.token, t.modTime = token, info.ModTime()
+	return token, nil
+}
+
+// SwitchToken implements TokenSwitcher. The file is replaced atomically.
+func (t *FileToken) SwitchToken(_ context.Context, token string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tmp, err := ioutil.TempFile(filepath.Dir(t.path), filepath.Base(t.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(token + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), t.path); err != nil {
+		return err
+	}
+	// The new token is not cached, as the modification time of the file
+	// may not have changed.
+	t.token = ""
+	return nil
+}
+
+// RotateToken replaces the token of switcher with a new API token named name,
+// created with c, and returns it. Once the new token has been verified by
+// listing tokens with it, the previous one is deleted. If any step fails
+// before the switch is complete, the previous token is restored and the new
+// one deleted, so that clients keep working.
+//
+// switcher may be the TokenSource of c itself, or that of other clients.
+func (c *Client) RotateToken(ctx context.Context, name string, switcher TokenSwitcher) (string, error) {
+	previous, err := switcher.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("routemaster: getting current token failed: %w", err)
+	}
+	token, err := c.CreateTokenContext(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("routemaster: creating token failed: %w", err)
+	}
+	if token == "" {
+		return "", errors.New("routemaster: creating token failed: empty token")
+	}
+
+	rollback := func(cause error) error {
+		if err := switcher.SwitchToken(ctx, previous); err != nil {
+			cause = fmt.Errorf("%w (restoring previous token failed: %v)", cause, err)
+		}
+		if err := c.withToken(StaticToken(previous)).DeleteTokenContext(ctx, token); err != nil {
+			cause = fmt.Errorf("%w (deleting new token failed: %v)", cause, err)
+		}
+		return cause
+	}
+	if err := switcher.SwitchToken(ctx, token); err != nil {
+		return "", rollback(fmt.Errorf("routemaster: switching token failed: %w", err))
+	}
+	if got, err := switcher.Token(ctx); err != nil || got != token {
+		if err == nil {
+			err = errors.New("token not updated")
+		}
+		return "", rollback(fmt.Errorf("routemaster: switching token failed: %w", err))
+	}
+	if _, err := c.withToken(StaticToken(token)).GetTokensContext(ctx); err != nil {
+		return "", rollback(fmt.Errorf("routemaster: verifying token failed: %w", err))
+	}
+
+	if previous != token {
+		if err := c.withToken(StaticToken(token)).DeleteTokenContext(ctx, previous); err != nil {
+			return token, fmt.Errorf("routemaster: deleting previous token failed: %w", err)
+		}
+	}
+	return token, nil
+}
+
+// withToken returns a copy of c authenticating with tokens.
+func (c *Client) withToken(tokens TokenSource) *Client {
+	clone := *c
+	clone.tokens = tokens
+	return &clone
+}
@@ -0,0 +1,125 @@
+package routemaster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tokenServer is a fake bus accepting the API tokens it holds. If reject is
+// set, the tokens it creates are not accepted.
+type tokenServer struct {
+	mu     sync.Mutex
+	tokens map[string]bool
+	reject bool
+	next   int
+	used   []string
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	username, _, _ := r.BasicAuth()
+	s.used = append(s.used, username)
+	if !s.tokens[username] {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet:
+		w.Write([]byte("[]"))
+	case r.Method == http.MethodPost:
+		s.next++
+		token := strings.Repeat("n", s.next)
+		s.tokens[token] = !s.reject
+		json.NewEncoder(w).Encode(Token{Token: token})
+	case r.Method == http.MethodDelete:
+		delete(s.tokens, strings.TrimPrefix(r.URL.Path, "/api_tokens/"))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestClientTokenSource(t *testing.T) {
+	bus := &tokenServer{tokens: map[string]bool{"old": true}}
+	ts := httptest.NewServer(bus)
+	defer ts.Close()
+
+	source := NewSwitchableToken("old")
+	client, err := NewClient(&Config{URL: ts.URL, TokenSource: source})
+	must(err)
+	other, err := NewClient(&Config{URL: ts.URL, TokenSource: source})
+	must(err)
+
+	token, err := client.RotateToken(context.Background(), "app", source)
+	must(err)
+	if token != "n" {
+		t.Errorf("token: got %q, want %q", token, "n")
+	}
+	if bus.tokens["old"] || !bus.tokens["n"] {
+		t.Errorf("tokens: got %v", bus.tokens)
+	}
+	if _, err := other.GetTokens(); err != nil {
+		t.Errorf("other client: %v", err)
+	}
+	if got := bus.used[len(bus.used)-1]; got != "n" {
+		t.Errorf("other client token: got %q, want %q", got, "n")
+	}
+
+	t.Run("failed verification", func(t *testing.T) {
+		bus.mu.Lock()
+		bus.reject = true
+		bus.mu.Unlock()
+		if _, err := client.RotateToken(context.Background(), "app", source); err == nil {
+			t.Fatal("expected an error")
+		}
+		if got, _ := source.Token(context.Background()); got != "n" {
+			t.Errorf("token after rollback: got %q, want %q", got, "n")
+		}
+		if want := map[string]bool{"n": true}; !reflect.DeepEqual(bus.tokens, want) {
+			t.Errorf("tokens after rollback: got %v, want %v", bus.tokens, want)
+		}
+	})
+}
+
+func TestFileToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	must(os.WriteFile(path, []byte("old\n"), 0o600))
+	source := NewFileToken(path)
+	reader := NewFileToken(path)
+
+	token, err := source.Token(context.Background())
+	must(err)
+	if token != "old" {
+		t.Errorf("got %q, want %q", token, "old")
+	}
+
+	must(source.SwitchToken(context.Background(), "new"))
+	for _, s := range []*FileToken{source, reader} {
+		token, err := s.Token(context.Background())
+		must(err)
+		if token != "new" {
+			t.Errorf("after switch: got %q, want %q", token, "new")
+		}
+	}
+
+	// The file is read again once modified.
+	must(os.WriteFile(path, []byte("  external  "), 0o600))
+	must(os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	if token, _ := reader.Token(context.Background()); token != "external" {
+		t.Errorf("after update: got %q, want %q", token, "external")
+	}
+
+	must(os.WriteFile(path, nil, 0o600))
+	must(os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	if _, err := reader.Token(context.Background()); err == nil {
+		t.Error("expected an error for an empty file")
+	}
+}
@@ -0,0 +1,76 @@
+package routemaster
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+// Middleware wraps an http.RoundTripper to observe or alter the requests made
+// by a Client, e.g. to log them, add headers or sign them.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function into an http.RoundTripper.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// HeaderMiddleware returns a Middleware setting the given headers on every
+// request.
+func HeaderMiddleware(header http.Header) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// RoundTrippers must not modify the request they are given.
+			req = req.Clone(req.Context())
+			for key, values := range header {
+				req.Header[key] = append([]string(nil), values...)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// newHTTPClient builds the http.Client used by a Client from its config.
+func newHTTPClient(config *Config) (*http.Client, error) {
+	base := config.HTTPClient
+	if base == nil {
+		base = http.DefaultClient
+	}
+	if !config.IgnoreSSL && config.TLS == nil && len(config.Middleware) == 0 {
+		return base, nil
+	}
+
+	transport := base.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if config.IgnoreSSL || config.TLS != nil {
+		tr, ok := transport.(*http.Transport)
+		if !ok {
+			return nil, errors.New("routemaster: IgnoreSSL and TLS require the transport of HTTPClient to be an *http.Transport")
+		}
+		tr = tr.Clone()
+		if config.TLS != nil {
+			tlsConfig, err := config.TLS.Build()
+			if err != nil {
+				return nil, err
+			}
+			tr.TLSClientConfig = tlsConfig
+		}
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{}
+		}
+		tr.TLSClientConfig.InsecureSkipVerify = config.IgnoreSSL
+		transport = tr
+	}
+	for i := len(config.Middleware) - 1; i >= 0; i-- {
+		transport = config.Middleware[i](transport)
+	}
+
+	client := *base
+	client.Transport = transport
+	return &client, nil
+}
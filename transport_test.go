@@ -0,0 +1,76 @@
+package routemaster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	var gotHeader string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get("X-Request-Id")
+	}))
+	defer ts.Close()
+
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, name+" "+req.Header.Get("X-Request-Id"))
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	httpClient := &http.Client{}
+	client, err := NewClient(&Config{
+		URL:        ts.URL,
+		UUID:       "demo",
+		HTTPClient: httpClient,
+		Middleware: []Middleware{
+			trace("outer"),
+			HeaderMiddleware(http.Header{"X-Request-Id": {"123"}}),
+			trace("inner"),
+		},
+	})
+	must(err)
+	must(client.DeleteTopic("orders"))
+
+	if want := []string{"outer ", "inner 123"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls: got %v, want %v", calls, want)
+	}
+	if gotHeader != "123" {
+		t.Errorf("header: got %q, want %q", gotHeader, "123")
+	}
+	if httpClient.Transport != nil {
+		t.Error("HTTPClient was modified")
+	}
+}
+
+func TestIgnoreSSL(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	client, err := NewClient(&Config{URL: ts.URL, UUID: "demo"})
+	must(err)
+	if err := client.DeleteTopic("orders"); err == nil {
+		t.Error("expected certificate error")
+	}
+
+	for _, httpClient := range []*http.Client{nil, {Transport: &http.Transport{}}} {
+		client, err = NewClient(&Config{URL: ts.URL, UUID: "demo", IgnoreSSL: true, HTTPClient: httpClient})
+		must(err)
+		must(client.DeleteTopic("orders"))
+	}
+
+	_, err = NewClient(&Config{
+		URL:        ts.URL,
+		UUID:       "demo",
+		IgnoreSSL:  true,
+		HTTPClient: &http.Client{Transport: RoundTripperFunc(http.DefaultTransport.RoundTrip)},
+	})
+	if err == nil {
+		t.Error("expected error for IgnoreSSL with a custom transport")
+	}
+}
@@ -0,0 +1,72 @@
+package routemaster
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DecodeError is returned when the data of a received event cannot be
+// decoded.
+type DecodeError struct {
+	// Event is the event whose data could not be decoded.
+	Event *ReceivedEvent
+
+	// Err is the error returned by the JSON decoder.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("routemaster: cannot decode data of %s event %s on topic %s: %v",
+		e.Event.Type, e.Event.URL, e.Event.Topic, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeData decodes the data of a received event into a value of type T.
+// Events without data decode to the zero value of T.
+func DecodeData[T any](e *ReceivedEvent) (T, error) {
+	var v T
+	if len(e.Data) == 0 {
+		return v, nil
+	}
+	if err := json.Unmarshal(e.Data, &v); err != nil {
+		return v, &DecodeError{Event: e, Err: err}
+	}
+	return v, nil
+}
+
+// TypedEvent is a received event along with its decoded data.
+type TypedEvent[T any] struct {
+	*ReceivedEvent
+
+	// Data is the decoded data of the event. It shadows the raw data of the
+	// embedded ReceivedEvent.
+	Data T
+}
+
+// TypedHandler adapts a handler of events whose data is of type T into a
+// HandlerFunc. It is typically registered for a single topic on a Mux:
+//
+//	mux.Handle("orders", routemaster.TypedHandler(
+//		func(events []*routemaster.TypedEvent[Order]) error {
+//			...
+//		}))
+//
+// If the data of any event in the batch cannot be decoded, h is not invoked
+// and a *DecodeError identifying the event is returned, which the Listener
+// reports to its OnError function.
+func TypedHandler[T any](h func([]*TypedEvent[T]) error) HandlerFunc {
+	return func(events []*ReceivedEvent) error {
+		typed := make([]*TypedEvent[T], len(events))
+		for i, e := range events {
+			data, err := DecodeData[T](e)
+			if err != nil {
+				return err
+			}
+			typed[i] = &TypedEvent[T]{ReceivedEvent: e, Data: data}
+		}
+		return h(typed)
+	}
+}
@@ -0,0 +1,73 @@
+package routemaster
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testOrder struct {
+	RestaurantID int `json:"restaurant_id"`
+}
+
+func TestDecodeData(t *testing.T) {
+	order, err := DecodeData[testOrder](&ReceivedEvent{Data: []byte(`{"restaurant_id": 123}`)})
+	must(err)
+	if order.RestaurantID != 123 {
+		t.Errorf("restaurant_id: got %d, want %d", order.RestaurantID, 123)
+	}
+
+	order, err = DecodeData[testOrder](&ReceivedEvent{})
+	must(err)
+	if order.RestaurantID != 0 {
+		t.Errorf("restaurant_id: got %d, want %d", order.RestaurantID, 0)
+	}
+
+	event := &ReceivedEvent{Data: []byte(`"not an order"`)}
+	_, err = DecodeData[testOrder](event)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Event != event {
+		t.Errorf("error: got %v, want DecodeError for event", err)
+	}
+}
+
+func TestTypedHandler(t *testing.T) {
+	var (
+		received []*TypedEvent[testOrder]
+		reported error
+	)
+	listener := NewListener(&ListenerConfig{
+		Handler: TypedHandler(func(events []*TypedEvent[testOrder]) error {
+			received = events
+			return nil
+		}),
+		OnError: func(err error) { reported = err },
+		UUID:    "secret",
+	})
+	post := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		req.SetBasicAuth("secret", "")
+		w := httptest.NewRecorder()
+		listener.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	code := post(`[{"topic": "orders", "type": "create", "url": "https://orders/1", "data": {"restaurant_id": 123}}]`)
+	if code != http.StatusOK {
+		t.Fatalf("status: got %d, want %d", code, http.StatusOK)
+	}
+	if len(received) != 1 || received[0].Data.RestaurantID != 123 || received[0].URL != "https://orders/1" {
+		t.Errorf("received: got %+v", received)
+	}
+
+	code = post(`[{"topic": "orders", "type": "create", "url": "https://orders/2", "data": [1, 2]}]`)
+	if code != http.StatusInternalServerError {
+		t.Fatalf("status: got %d, want %d", code, http.StatusInternalServerError)
+	}
+	var decodeErr *DecodeError
+	if !errors.As(reported, &decodeErr) || decodeErr.Event.URL != "https://orders/2" {
+		t.Errorf("reported error: got %v, want DecodeError for https://orders/2", reported)
+	}
+}